@@ -0,0 +1,65 @@
+package gocache
+
+// admin lets operators change the peers of a live node, over the Admin grpc service
+// next to GroupCache (once enabled by GrpcPool.SetAdmin) or over http with AdminHandler
+// on a private port. Neither has auth. A change only applies to the
+// node it's sent to, so it should be sent to every node of the cluster, e.g.
+//
+//	curl -X POST 'localhost:9100/admin/peers?peer=:8004'
+//	grpcurl -plaintext -d '{"peers":[":8004"]}' localhost:8001 gocachepb.Admin/AddPeers
+
+import (
+	"context"
+	"encoding/json"
+	pb "gocache/gocachepb"
+	"net/http"
+)
+
+// adminServer implements the Admin grpc service on a pool
+type adminServer struct {
+	pb.UnimplementedAdminServer
+	pool Membership
+}
+
+func (s *adminServer) ListPeers(ctx context.Context, in *pb.Peers) (*pb.Peers, error) {
+	return &pb.Peers{Peers: s.pool.Peers()}, nil
+}
+
+func (s *adminServer) AddPeers(ctx context.Context, in *pb.Peers) (*pb.Peers, error) {
+	s.pool.AddPeer(in.Peers...)
+	return &pb.Peers{Peers: s.pool.Peers()}, nil
+}
+
+func (s *adminServer) RemovePeers(ctx context.Context, in *pb.Peers) (*pb.Peers, error) {
+	s.pool.RemovePeer(in.Peers...)
+	return &pb.Peers{Peers: s.pool.Peers()}, nil
+}
+
+func (s *adminServer) SetPeers(ctx context.Context, in *pb.Peers) (*pb.Peers, error) {
+	s.pool.SetPeers(in.Peers...)
+	return &pb.Peers{Peers: s.pool.Peers()}, nil
+}
+
+// AdminHandler returns the handler changing the peers of pool, the peers are
+// given as ?peer= query parameters and the response is the JSON list of peers
+// after the change.
+// GET lists, POST adds, DELETE removes and PUT replaces the peers.
+func AdminHandler(pool Membership) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peers := r.URL.Query()["peer"]
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			pool.AddPeer(peers...)
+		case http.MethodDelete:
+			pool.RemovePeer(peers...)
+		case http.MethodPut:
+			pool.SetPeers(peers...)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pool.Peers())
+	})
+}
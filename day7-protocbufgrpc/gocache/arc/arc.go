@@ -0,0 +1,260 @@
+package arc
+
+// ARC (Adaptive Replacement Cache, Megiddo & Modha) keeps two LRU lists,
+// t1 for entries seen once recently and t2 for entries seen at least twice,
+// plus the ghost lists b1 and b2 with the keys recently evicted from them.
+// A hit in a ghost list shows which list was too small and moves the target
+// size p of t1. A scan only goes through t1 so the frequent entries in t2 survive.
+// Sizes are counted in bytes instead of entries to honor maxBytes like lru.Cache.
+
+import (
+	"container/list"
+	"gocache/lru"
+	"time"
+)
+
+// Cache is an ARC cache. It is not safe for concurrent access.
+type Cache struct {
+	maxBytes  int64
+	evictions int64
+	// p is the target bytes of t1, adapted by the ghost hits
+	p int64
+	// resident lists, front is most recently used
+	t1, t2 *arcList
+	// ghost lists, only keys and sizes of evicted entries
+	b1, b2 *arcList
+	// optional and executed when an entry is purged.
+	OnEvicted func(key string, value lru.Value)
+}
+
+// entry is data type of DLL node, value is nil in the ghost lists
+type entry struct {
+	key    string
+	value  lru.Value
+	size   int64     // bytes of key and value
+	expire time.Time // zero never expires
+	list   *arcList
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+// arcList is a DLL with its byte size and a key index
+type arcList struct {
+	dLL   *list.List
+	bytes int64
+	cache map[string]*list.Element
+}
+
+func newList() *arcList {
+	return &arcList{dLL: list.New(), cache: make(map[string]*list.Element)}
+}
+
+func (l *arcList) pushFront(e *entry) {
+	e.list = l
+	l.cache[e.key] = l.dLL.PushFront(e)
+	l.bytes += e.size
+}
+
+func (l *arcList) remove(e *list.Element) *entry {
+	kvPair := e.Value.(*entry)
+	l.dLL.Remove(e)
+	delete(l.cache, kvPair.key)
+	l.bytes -= kvPair.size
+	return kvPair
+}
+
+// New is the Constructor of Cache, maxBytes 0 or less means no limit
+func New(maxBytes int64, onEvicted func(string, lru.Value)) *Cache {
+	return &Cache{
+		maxBytes:  maxBytes,
+		t1:        newList(),
+		t2:        newList(),
+		b1:        newList(),
+		b2:        newList(),
+		OnEvicted: onEvicted,
+	}
+}
+
+// lookup finds key in the resident lists
+func (c *Cache) lookup(key string) (*list.Element, bool) {
+	if e, ok := c.t1.cache[key]; ok {
+		return e, true
+	}
+	e, ok := c.t2.cache[key]
+	return e, ok
+}
+
+// Get look ups a key's value, a hit moves the entry to the front of t2
+// an expired entry is removed lazily here and reported as a miss
+func (c *Cache) Get(key string) (value lru.Value, ok bool) {
+	e, ok := c.lookup(key)
+	if !ok {
+		return nil, false
+	}
+	kvPair := e.Value.(*entry)
+	if kvPair.expired(time.Now()) {
+		c.removeElement(e)
+		return nil, false
+	}
+	c.t2.pushFront(kvPair.list.remove(e))
+	return kvPair.value, true
+}
+
+// Add adds a value to the cache.
+func (c *Cache) Add(key string, value lru.Value) {
+	c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithExpire adds a value to the cache which is valid until expire,
+// zero expire means the value never expires.
+func (c *Cache) AddWithExpire(key string, value lru.Value, expire time.Time) {
+	size := int64(len(key)) + int64(value.Len())
+	kvPair := &entry{key: key, value: value, size: size, expire: expire}
+	inB2 := false
+
+	switch {
+	case c.contains(c.t1, key) || c.contains(c.t2, key):
+		// update, the second access promotes the entry to t2
+		e, _ := c.lookup(key)
+		e.Value.(*entry).list.remove(e)
+		c.t2.pushFront(kvPair)
+	case c.contains(c.b1, key):
+		// t1 was too small for key, grow its target
+		c.b1.remove(c.b1.cache[key])
+		c.p = min64(c.p+c.delta(size, c.b2, c.b1), c.maxBytes)
+		c.t2.pushFront(kvPair)
+	case c.contains(c.b2, key):
+		// t2 was too small for key, shrink the target of t1
+		c.b2.remove(c.b2.cache[key])
+		c.p = max64(c.p-c.delta(size, c.b1, c.b2), 0)
+		c.t2.pushFront(kvPair)
+		inB2 = true
+	default:
+		c.t1.pushFront(kvPair)
+	}
+
+	// align with lru, if c.maxBytes <= 0 means no limit
+	for c.maxBytes > 0 && c.t1.bytes+c.t2.bytes > c.maxBytes && c.t1.dLL.Len()+c.t2.dLL.Len() > 0 {
+		c.replace(inB2)
+	}
+	c.trimGhosts()
+}
+
+// delta is how much p moves on a ghost hit, more when the other ghost list is bigger
+func (c *Cache) delta(size int64, other, hit *arcList) int64 {
+	if hit.bytes > 0 && other.bytes > hit.bytes {
+		return size * (other.bytes / hit.bytes)
+	}
+	return size
+}
+
+func (c *Cache) contains(l *arcList, key string) bool {
+	_, ok := l.cache[key]
+	return ok
+}
+
+// replace evicts the LRU entry of t1 or t2 into its ghost list, t1 is chosen
+// while it's over its target p
+func (c *Cache) replace(inB2 bool) {
+	t1Bytes := c.t1.bytes
+	if t1Bytes > 0 && (t1Bytes > c.p || (inB2 && t1Bytes == c.p) || c.t2.bytes == 0) {
+		c.evict(c.t1, c.b1)
+	} else if c.t2.bytes > 0 {
+		c.evict(c.t2, c.b2)
+	}
+}
+
+func (c *Cache) evict(from, ghost *arcList) {
+	e := from.dLL.Back()
+	if e == nil {
+		return
+	}
+	kvPair := from.remove(e)
+	ghost.pushFront(&entry{key: kvPair.key, size: kvPair.size})
+	c.evictions++
+	if c.OnEvicted != nil {
+		c.OnEvicted(kvPair.key, kvPair.value)
+	}
+}
+
+// trimGhosts keeps each ghost list under maxBytes (or the resident bytes without
+// limit), so the ghosts remember at most the keys of two caches
+func (c *Cache) trimGhosts() {
+	limit := c.maxBytes
+	if limit <= 0 {
+		limit = c.t1.bytes + c.t2.bytes
+	}
+	for _, ghost := range []*arcList{c.b1, c.b2} {
+		for ghost.bytes > limit && ghost.dLL.Len() > 0 {
+			ghost.remove(ghost.dLL.Back())
+		}
+	}
+}
+
+// Remove removes the key from the cache, OnEvicted is called if it was there
+func (c *Cache) Remove(key string) {
+	if e, ok := c.lookup(key); ok {
+		c.removeElement(e)
+	}
+}
+
+// RemoveOldest evicts an entry following the ARC replacement, named after lru.Cache
+func (c *Cache) RemoveOldest() {
+	c.replace(false)
+	c.trimGhosts()
+}
+
+// RemoveExpired removes all the expired entries and returns how many were removed
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	removed := 0
+	for _, l := range []*arcList{c.t1, c.t2} {
+		for _, e := range l.cache {
+			if e.Value.(*entry).expired(now) {
+				c.removeElement(e)
+				removed++
+			}
+		}
+	}
+	return removed
+}
+
+// removeElement removes a resident entry without keeping a ghost, it was not evicted
+func (c *Cache) removeElement(e *list.Element) {
+	kvPair := e.Value.(*entry)
+	kvPair.list.remove(e)
+	if c.OnEvicted != nil {
+		c.OnEvicted(kvPair.key, kvPair.value)
+	}
+}
+
+// Len the number of cache entries
+func (c *Cache) Len() int {
+	return c.t1.dLL.Len() + c.t2.dLL.Len()
+}
+
+// Bytes the number of bytes used by keys and values
+func (c *Cache) Bytes() int64 {
+	return c.t1.bytes + c.t2.bytes
+}
+
+// Evictions the number of entries evicted by the replacement
+func (c *Cache) Evictions() int64 {
+	return c.evictions
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,101 @@
+package arc
+
+import (
+	"fmt"
+	"gocache/lru"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (d String) Len() int {
+	return len(d)
+}
+
+func TestGet(t *testing.T) {
+	arc := New(int64(0), nil)
+	arc.Add("key1", String("1234"))
+	if v, ok := arc.Get("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("cache hit key1=1234 failed")
+	}
+	if _, ok := arc.Get("key2"); ok {
+		t.Fatalf("cache miss key2 failed")
+	}
+}
+
+func TestNegativeSize(t *testing.T) {
+	arc := New(int64(-1), nil)
+	arc.Add("key1", String("1234"))
+	arc.Add("key2", String("5678"))
+	if arc.Len() != 2 {
+		t.Fatalf("negative maxBytes should not evict, %d left", arc.Len())
+	}
+}
+
+func TestOnEvicted(t *testing.T) {
+	keys := make([]string, 0)
+	arc := New(int64(8), func(key string, value lru.Value) {
+		keys = append(keys, key)
+	})
+	arc.Add("k1", String("v1"))
+	arc.Add("k2", String("v2"))
+	arc.Add("k3", String("v3"))
+	arc.Remove("k2")
+
+	if expect := []string{"k1", "k2"}; !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("Call OnEvicted failed, expect keys equals to %+v, got %v", expect, keys)
+	}
+	if arc.Len() != 1 || arc.Bytes() != 4 || arc.Evictions() != 1 {
+		t.Fatalf("expect 1 entry of 4 bytes and 1 eviction, got %d %d %d", arc.Len(), arc.Bytes(), arc.Evictions())
+	}
+}
+
+func TestScanResistance(t *testing.T) {
+	// room for 5 entries of 4 bytes
+	arc := New(int64(20), nil)
+	for _, key := range []string{"h1", "h2", "h3"} {
+		arc.Add(key, String("v1"))
+		arc.Get(key)
+	}
+	for i := 0; i < 100; i++ {
+		arc.Add(fmt.Sprintf("%02d", i), String("v2"))
+	}
+	for _, key := range []string{"h1", "h2", "h3"} {
+		if _, ok := arc.Get(key); !ok {
+			t.Fatalf("frequent key %s should survive the scan", key)
+		}
+	}
+	if arc.Bytes() > 20 {
+		t.Fatalf("expect at most 20 bytes, got %d", arc.Bytes())
+	}
+}
+
+func TestGhostHit(t *testing.T) {
+	arc := New(int64(8), nil)
+	arc.Add("k1", String("v1"))
+	arc.Add("k2", String("v2"))
+	arc.Add("k3", String("v3"))
+	// k1 is a ghost of t1 now, adding it again grows the target of t1
+	arc.Add("k1", String("v1"))
+	if arc.p == 0 {
+		t.Fatalf("a hit in b1 should grow p")
+	}
+	if _, ok := arc.Get("k1"); !ok {
+		t.Fatalf("k1 should be added back")
+	}
+}
+
+func TestExpire(t *testing.T) {
+	arc := New(int64(0), nil)
+	arc.AddWithExpire("k1", String("v1"), time.Now().Add(-time.Second))
+	arc.AddWithExpire("k2", String("v2"), time.Now().Add(-time.Second))
+	arc.AddWithExpire("k3", String("v3"), time.Now().Add(time.Hour))
+	if _, ok := arc.Get("k1"); ok {
+		t.Fatalf("k1 should expire")
+	}
+	if n := arc.RemoveExpired(); n != 1 || arc.Len() != 1 {
+		t.Fatalf("RemoveExpired should remove k2, removed %d", n)
+	}
+}
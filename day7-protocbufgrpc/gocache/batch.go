@@ -0,0 +1,239 @@
+package gocache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pb "gocache/gocachepb"
+	"sync"
+	"time"
+)
+
+// A BatchGetter is a Getter which also loads many keys in one go, e.g. with one
+// query to the database, GetMany uses it for the keys missing on this node.
+// The keys missing from the returned map are not found, they are cached as ErrNotFound.
+type BatchGetter interface {
+	Getter
+	GetBatch(ctx context.Context, keys []string) (map[string][]byte, error)
+}
+
+// A BatchGetterFunc implements BatchGetter with a function, Get loads one key with it
+type BatchGetterFunc func(ctx context.Context, keys []string) (map[string][]byte, error)
+
+// GetBatch implements BatchGetter interface function
+func (f BatchGetterFunc) GetBatch(ctx context.Context, keys []string) (map[string][]byte, error) {
+	return f(ctx, keys)
+}
+
+// Get implements Getter interface function without deadline
+func (f BatchGetterFunc) Get(key string) ([]byte, error) {
+	values, err := f(context.Background(), []string{key})
+	if err != nil {
+		return nil, err
+	}
+	value, ok := values[key]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	return value, nil
+}
+
+// GetMany gets the values of keys, the cache misses are grouped by the peer owning
+// them and each peer is asked for its keys in one request, then the misses owned
+// by this node or whose peer failed are loaded at once with a BatchGetter or one
+// by one with the Getter. The keys that failed to load are missing from values
+// and err is the first of their errors. Unlike Get the loads are not shared with
+// the concurrent Gets of the same keys, and replicas and hedging are not used.
+func (g *Group) GetMany(ctx context.Context, keys []string) (map[string]ByteView, error) {
+	values, err := g.getMany(ctx, keys)
+	for key, value := range values {
+		if value.missing {
+			delete(values, key)
+			if err == nil {
+				err = fmt.Errorf("%s: %w", key, ErrNotFound)
+			}
+		}
+	}
+	return values, err
+}
+
+// getMany is GetMany keeping the keys not found in values as negative entries,
+// err is the first error of the other keys
+func (g *Group) getMany(ctx context.Context, keys []string) (values map[string]ByteView, err error) {
+	values = make(map[string]ByteView, len(keys))
+	var missed []string
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		g.stats.gets.Add(1)
+		if key == "" {
+			err = fmt.Errorf("key is required")
+			continue
+		}
+		if v, ok := g.lookupCache(key); ok {
+			g.stats.cacheHits.Add(1)
+			if v.missing {
+				g.stats.negativeHits.Add(1)
+			}
+			values[key] = v
+			continue
+		}
+		if !g.mayExist(key) {
+			values[key] = ByteView{missing: true}
+			continue
+		}
+		missed = append(missed, key)
+	}
+	if len(missed) == 0 {
+		return values, err
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return values, ctxErr
+	}
+	ctx = g.loadStarted(ctx)
+	g.stats.loads.Add(int64(len(missed)))
+	g.stats.loadsDeduped.Add(int64(len(missed)))
+	defer func(start time.Time) { g.loadLatency.observe(time.Since(start)) }(time.Now())
+
+	// group the misses by owner, a request from a peer is loaded here as in load
+	var local []string
+	remote := make(map[PeerClient][]string)
+	for _, key := range missed {
+		if g.picker != nil && !isPeerRequest(ctx) {
+			if peer, ok := g.picker.PickPeer(key); ok {
+				remote[peer] = append(remote[peer], key)
+				continue
+			}
+		}
+		local = append(local, key)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for peer, keys := range remote {
+		wg.Add(1)
+		go func(peer PeerClient, keys []string) {
+			defer wg.Done()
+			found := g.getManyFromRemote(ctx, peer, keys)
+			mu.Lock()
+			defer mu.Unlock()
+			for _, key := range keys {
+				if value, ok := found[key]; ok {
+					values[key] = value
+				} else {
+					// like load we fall back to the local source
+					local = append(local, key)
+				}
+			}
+		}(peer, keys)
+	}
+	wg.Wait()
+	if len(local) == 0 {
+		return values, err
+	}
+	// the caller gave up, dont fall back to the local source
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return values, ctxErr
+	}
+	localErr := g.getManyLocal(ctx, local, values)
+	if err == nil {
+		err = localErr
+	}
+	return values, err
+}
+
+// getManyFromRemote gets keys from peer in one request if it's a MultiPeerClient,
+// one by one otherwise. It returns the values found and the negative entries of
+// the keys the peer didnt find.
+func (g *Group) getManyFromRemote(ctx context.Context, peer PeerClient, keys []string) map[string]ByteView {
+	found := make(map[string]ByteView, len(keys))
+	multi, ok := peer.(MultiPeerClient)
+	if !ok {
+		for _, key := range keys {
+			if value, err := g.getFromRemote(ctx, peer, key, false); err == nil {
+				g.stats.peerLoads.Add(1)
+				found[key] = value
+			} else if errors.Is(err, ErrNotFound) {
+				found[key] = g.cacheNotFound(ctx, key, &g.hotCache)
+			} else {
+				g.stats.peerErrors.Add(1)
+			}
+		}
+		return found
+	}
+	resp := &pb.MultiResponse{}
+	if err := multi.GetMulti(ctx, &pb.MultiRequest{Group: g.name, Keys: keys}, resp); err != nil {
+		g.stats.peerErrors.Add(1)
+		return found
+	}
+	for key, value := range resp.Values {
+		g.stats.peerLoads.Add(1)
+		found[key] = g.remoteValue(ctx, key, value, false)
+	}
+	for _, key := range resp.NotFound {
+		found[key] = g.cacheNotFound(ctx, key, &g.hotCache)
+	}
+	return found
+}
+
+// getManyLocal loads keys from the local source into values, the keys not found
+// as negative entries. It returns the first error of the others.
+func (g *Group) getManyLocal(ctx context.Context, keys []string, values map[string]ByteView) (err error) {
+	if g.batchGetter == nil {
+		for _, key := range keys {
+			value, localErr := g.getLocal(ctx, key)
+			// getLocal cached it already
+			if errors.Is(localErr, ErrNotFound) {
+				values[key] = ByteView{missing: true}
+				continue
+			}
+			if localErr != nil {
+				if err == nil {
+					err = localErr
+				}
+				continue
+			}
+			values[key] = value
+		}
+		return err
+	}
+	found, err := g.batchGetter.GetBatch(ctx, keys)
+	if err != nil {
+		g.stats.localLoadErrs.Add(int64(len(keys)))
+		return err
+	}
+	for _, key := range keys {
+		bytes, ok := found[key]
+		if !ok {
+			g.stats.localLoadErrs.Add(1)
+			values[key] = g.cacheNotFound(ctx, key, &g.mainCache)
+			continue
+		}
+		g.stats.localLoads.Add(1)
+		g.addKey(key)
+		value := ByteView{b: cloneBytes(bytes), e: g.expireAt(0)}
+		g.cacheLoaded(ctx, key, value, &g.mainCache)
+		values[key] = value
+	}
+	return err
+}
+
+// multiResponse is the answer to a GetMulti of a peer with the values of getMany
+func multiResponse(values map[string]ByteView) *pb.MultiResponse {
+	response := &pb.MultiResponse{Values: make(map[string]*pb.Response, len(values))}
+	for key, value := range values {
+		if value.missing {
+			response.NotFound = append(response.NotFound, key)
+			continue
+		}
+		resp := &pb.Response{Value: value.ByteSlice()}
+		if !value.Expire().IsZero() {
+			resp.Expire = value.Expire().UnixNano()
+		}
+		response.Values[key] = resp
+	}
+	return response
+}
@@ -0,0 +1,55 @@
+package gocache
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestGetMany(t *testing.T) {
+	var batches [][]string
+	scores := NewGroup("manyscores", 2<<10, BatchGetterFunc(
+		func(ctx context.Context, keys []string) (map[string][]byte, error) {
+			batches = append(batches, append([]string(nil), keys...))
+			values := make(map[string][]byte)
+			for _, key := range keys {
+				if v, ok := db[key]; ok {
+					values[key] = []byte(v)
+				}
+			}
+			return values, nil
+		}))
+	peers := &testPeers{}
+	scores.RegisterNodes(peers)
+
+	keys := []string{"Tom", "remoteTom", "Jack", "Tom", "unknown"}
+	values, err := scores.GetMany(context.Background(), keys)
+	if err == nil {
+		t.Fatalf("expect the error of unknown")
+	}
+	expect := map[string]string{"Tom": "630", "Jack": "589", "remoteTom": "remoteTom-value"}
+	if len(values) != len(expect) {
+		t.Fatalf("got %d values, expect %d", len(values), len(expect))
+	}
+	for key, value := range expect {
+		if values[key].String() != value {
+			t.Fatalf("%s = %q, expect %q", key, values[key].String(), value)
+		}
+	}
+	// the local misses are loaded at once, the remote ones asked to their peer
+	if len(batches) != 1 || !reflect.DeepEqual(batches[0], []string{"Tom", "Jack", "unknown"}) {
+		t.Fatalf("unexpected batches %v", batches)
+	}
+	if peers.gets != 1 {
+		t.Fatalf("peer asked %d times, expect 1", peers.gets)
+	}
+
+	// the loaded values are cached
+	if values, err := scores.GetMany(context.Background(), []string{"Tom", "Jack"}); err != nil || len(values) != 2 || len(batches) != 1 {
+		t.Fatalf("cached values should not be loaded again, %d batches %v", len(batches), err)
+	}
+	// a single Get uses the batch getter too
+	if view, err := scores.Get("Sam"); err != nil || view.String() != "567" {
+		t.Fatalf("failed to get Sam, got %q %v", view.String(), err)
+	}
+}
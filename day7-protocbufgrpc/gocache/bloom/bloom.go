@@ -0,0 +1,139 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// Filter is a Bloom filter of string keys: Test is false for the keys never added
+// and true for the keys added, or by mistake for a small share of the others.
+// It is not safe for concurrent access.
+type Filter struct {
+	bits []uint64
+	// m is the number of bits, k the number of bits set per key
+	m, k uint64
+	// n is the number of keys added, duplicates included
+	n uint64
+}
+
+const (
+	// maxHashes caps k, more bits per key only cost time for tiny false positive rates
+	maxHashes = 32
+	// maxBits caps the size of a snapshot read back, 1<<36 bits are 8GB
+	maxBits = 1 << 36
+)
+
+// ErrInvalidSnapshot is returned by ReadFrom for data which is not a Filter written by WriteTo
+var ErrInvalidSnapshot = errors.New("bloom: invalid snapshot")
+
+// New creates a Filter for n keys with a false positive rate of p, e.g. 0.01.
+// Past n keys the filter still works but the false positives grow.
+func New(n int, p float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+	// the optimal sizes: m = -n ln p / (ln 2)^2 and k = m/n ln 2
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	// round up to whole words
+	m = (m + 63) / 64 * 64
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	if k > maxHashes {
+		k = maxHashes
+	}
+	return &Filter{bits: make([]uint64, m/64), m: m, k: k}
+}
+
+// hashes returns the two hashes of key combined into the k bit indexes,
+// the fnv-1a hash and a mix of it
+func hashes(key string) (h1, h2 uint64) {
+	h1 = 14695981039346656037
+	for i := 0; i < len(key); i++ {
+		h1 ^= uint64(key[i])
+		h1 *= 1099511628211
+	}
+	h2 = h1
+	h2 ^= h2 >> 33
+	h2 *= 0xff51afd7ed558ccd
+	h2 ^= h2 >> 33
+	// odd so the indexes dont repeat early
+	return h1, h2 | 1
+}
+
+// Add adds key to the filter
+func (f *Filter) Add(key string) {
+	h1, h2 := hashes(key)
+	for i := uint64(0); i < f.k; i++ {
+		j := (h1 + i*h2) % f.m
+		f.bits[j/64] |= 1 << (j % 64)
+	}
+	f.n++
+}
+
+// Test reports whether key may have been added, it's never false for a key added
+func (f *Filter) Test(key string) bool {
+	h1, h2 := hashes(key)
+	for i := uint64(0); i < f.k; i++ {
+		j := (h1 + i*h2) % f.m
+		if f.bits[j/64]&(1<<(j%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Len the number of keys added, a key added twice counts twice
+func (f *Filter) Len() int {
+	return int(f.n)
+}
+
+// Bytes the memory taken by the bits
+func (f *Filter) Bytes() int64 {
+	return int64(len(f.bits)) * 8
+}
+
+// Reset removes all the keys
+func (f *Filter) Reset() {
+	for i := range f.bits {
+		f.bits[i] = 0
+	}
+	f.n = 0
+}
+
+// WriteTo writes a snapshot of the filter to w, ReadFrom reads it back
+func (f *Filter) WriteTo(w io.Writer) (int64, error) {
+	header := [3]uint64{f.m, f.k, f.n}
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(w, binary.LittleEndian, f.bits); err != nil {
+		return int64(len(header)) * 8, err
+	}
+	return int64(len(header)+len(f.bits)) * 8, nil
+}
+
+// ReadFrom replaces the filter with a snapshot written by WriteTo, the filter
+// is left unchanged if the snapshot cannot be read
+func (f *Filter) ReadFrom(r io.Reader) (int64, error) {
+	var header [3]uint64
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return 0, err
+	}
+	m, k, n := header[0], header[1], header[2]
+	if m == 0 || m%64 != 0 || m > maxBits || k < 1 || k > maxHashes {
+		return int64(len(header)) * 8, ErrInvalidSnapshot
+	}
+	bits := make([]uint64, m/64)
+	if err := binary.Read(r, binary.LittleEndian, bits); err != nil {
+		return int64(len(header)) * 8, err
+	}
+	f.bits, f.m, f.k, f.n = bits, m, k, n
+	return int64(len(header)+len(bits)) * 8, nil
+}
@@ -0,0 +1,68 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestAddTest(t *testing.T) {
+	f := New(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.Add("key" + strconv.Itoa(i))
+	}
+	for i := 0; i < 1000; i++ {
+		if !f.Test("key" + strconv.Itoa(i)) {
+			t.Fatalf("key%d was added but not found", i)
+		}
+	}
+	if f.Len() != 1000 {
+		t.Fatalf("expect 1000 keys, got %d", f.Len())
+	}
+	// about 1% of the others pass, leave room for bad luck
+	fp := 0
+	for i := 0; i < 10000; i++ {
+		if f.Test("other" + strconv.Itoa(i)) {
+			fp++
+		}
+	}
+	if fp > 300 {
+		t.Fatalf("too many false positives: %d of 10000", fp)
+	}
+
+	f.Reset()
+	if f.Test("key1") || f.Len() != 0 {
+		t.Fatalf("reset filter should be empty")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	f := New(100, 0.01)
+	f.Add("Tom")
+	f.Add("Jack")
+	var buf bytes.Buffer
+	n, err := f.WriteTo(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("write %d bytes of %d: %v", n, buf.Len(), err)
+	}
+
+	restored := New(1, 0.5)
+	if _, err := restored.ReadFrom(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if !restored.Test("Tom") || !restored.Test("Jack") || restored.Len() != 2 || restored.Bytes() != f.Bytes() {
+		t.Fatalf("restored filter differs")
+	}
+
+	// a bad snapshot leaves the filter as it was
+	if _, err := restored.ReadFrom(bytes.NewReader(make([]byte, 24))); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Fatalf("expect ErrInvalidSnapshot, got %v", err)
+	}
+	if _, err := restored.ReadFrom(bytes.NewReader(buf.Bytes()[:30])); err == nil {
+		t.Fatalf("expect an error for a truncated snapshot")
+	}
+	if !restored.Test("Tom") {
+		t.Fatalf("failed read should not change the filter")
+	}
+}
@@ -0,0 +1,145 @@
+package gocache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrPeerUnavailable is returned without sending the request to a peer whose
+// circuit breaker is open, the caller falls back to another node right away
+var ErrPeerUnavailable = errors.New("peer unavailable")
+
+// default circuit breaker of the peers, see GrpcPool.SetCircuitBreaker
+const (
+	defaultBreakerFailures = 3
+	defaultBreakerCooldown = 5 * time.Second
+)
+
+type breakerState int
+
+const (
+	// breakerClosed lets the requests through
+	breakerClosed breakerState = iota
+	// breakerOpen fails the requests fast until the cooldown is over
+	breakerOpen
+	// breakerHalfOpen lets one trial request through, it closes or reopens the breaker
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	default:
+		return "half-open"
+	}
+}
+
+// breaker is the circuit breaker of a peer: it opens after failures consecutive
+// failures, then after cooldown it's half-open and the next request is a trial,
+// its success closes the breaker and its failure opens it for another cooldown.
+// Health probes report to it too, so a dead peer is found without a request.
+type breaker struct {
+	mu       sync.Mutex
+	failures int           // consecutive failures opening the breaker
+	cooldown time.Duration // how long it stays open
+	state    breakerState
+	failed   int       // consecutive failures so far
+	openedAt time.Time // when it was opened
+	trial    bool      // a half-open trial request is in flight
+}
+
+func newBreaker(failures int, cooldown time.Duration) *breaker {
+	return &breaker{failures: failures, cooldown: cooldown}
+}
+
+// set changes the settings, see GrpcPool.SetCircuitBreaker
+func (b *breaker) set(failures int, cooldown time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures, b.cooldown = failures, cooldown
+}
+
+// current returns the state, an open breaker is half-open once the cooldown is over, b.mu must be held
+func (b *breaker) current() breakerState {
+	if b.state == breakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		b.state = breakerHalfOpen
+	}
+	return b.state
+}
+
+// healthy reports whether requests may be sent to the peer, a half-open peer is
+// healthy so it gets its trial request
+func (b *breaker) healthy() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures <= 0 || b.current() != breakerOpen
+}
+
+// allow reports whether a request may be sent now, then done must be called with its result
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures <= 0 {
+		return true
+	}
+	switch b.current() {
+	case breakerOpen:
+		return false
+	case breakerHalfOpen:
+		// one trial at a time, the others fail fast until it's back
+		if b.trial {
+			return false
+		}
+		b.trial = true
+	}
+	return true
+}
+
+// abort ends a request whose caller gave up, its result says nothing about the peer
+func (b *breaker) abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// done records the result of a request or a probe
+func (b *breaker) done(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	if ok {
+		b.state, b.failed = breakerClosed, 0
+		return
+	}
+	b.failed++
+	if b.current() == breakerHalfOpen || (b.failures > 0 && b.failed >= b.failures) {
+		b.state, b.openedAt = breakerOpen, time.Now()
+	}
+}
+
+// peerFailed reports whether err of a request sent with the caller ctx is the
+// fault of the peer: it's down or too slow. The errors of the getter on the peer
+// and the callers giving up dont count.
+func peerFailed(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	// a http request without answer
+	var transportErr *transportError
+	if errors.As(err, &transportErr) {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package gocache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBreaker(t *testing.T) {
+	b := newBreaker(2, 20*time.Millisecond)
+	b.done(false)
+	if !b.allow() || !b.healthy() {
+		t.Fatalf("breaker opened before 2 failures")
+	}
+	b.done(false)
+	if b.allow() || b.healthy() {
+		t.Fatalf("breaker should be open after 2 failures")
+	}
+
+	// half-open after the cooldown, a single trial goes through
+	time.Sleep(30 * time.Millisecond)
+	if !b.healthy() || !b.allow() {
+		t.Fatalf("breaker should let a trial through after the cooldown")
+	}
+	if b.allow() {
+		t.Fatalf("only one trial at a time")
+	}
+	// a failed trial reopens it right away
+	b.done(false)
+	if b.allow() {
+		t.Fatalf("failed trial should reopen the breaker")
+	}
+	time.Sleep(30 * time.Millisecond)
+	b.allow()
+	b.done(true)
+	if b.state != breakerClosed || !b.allow() || !b.allow() {
+		t.Fatalf("successful trial should close the breaker, state %v", b.state)
+	}
+
+	// an aborted trial lets the next request try
+	b = newBreaker(1, 0)
+	b.done(false)
+	b.allow()
+	b.abort()
+	if !b.allow() {
+		t.Fatalf("aborted trial should not keep the breaker half-open")
+	}
+}
+
+func TestPeerFailed(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, c := range []struct {
+		ctx    context.Context
+		err    error
+		expect bool
+	}{
+		{context.Background(), nil, false},
+		{context.Background(), status.Error(codes.Unavailable, "down"), true},
+		{context.Background(), status.Error(codes.DeadlineExceeded, "slow"), true},
+		{context.Background(), status.Error(codes.Unknown, "key not exist"), false},
+		{context.Background(), errors.New("no such group"), false},
+		{cancelled, status.Error(codes.Canceled, "caller gave up"), false},
+	} {
+		if got := peerFailed(c.ctx, c.err); got != c.expect {
+			t.Errorf("peerFailed(%v) = %v, expect %v", c.err, got, c.expect)
+		}
+	}
+}
@@ -1,9 +1,20 @@
 package gocache
 
+import "time"
+
 // A ByteView holds an immutable view of cache bytes, it encapsulate cache Entry Value as unit of bytes
 // Len() method needs to be implemented for Value interface
 type ByteView struct {
 	b []byte
+	// e is when the value expires, zero time never expires
+	e time.Time
+	// missing marks a negative entry, the key was not found, see ErrNotFound
+	missing bool
+}
+
+// Expire returns when the view expires, the zero time means never
+func (v ByteView) Expire() time.Time {
+	return v.e
 }
 
 // Len returns the view's length, i.e. num of bytes
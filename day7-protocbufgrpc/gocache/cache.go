@@ -1,42 +1,211 @@
 package gocache
 
 import (
-	"fmt"
-	"gocache/lru"
-
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// cache wrapped lru.cache with mutex lock for concurrent run
+// cache splits the values into shards by key hash, each shard wraps an EvictionPolicy
+// with its own mutex, so concurrent gets of different keys dont wait for each other
 type cache struct {
-	mu       sync.Mutex // mutual exclusive lock
-	lru      *lru.Cache
-	maxBytes int64 //maxbytes
+	// newPolicy creates the policies on the first use, LRU when nil
+	newPolicy Policy
+	maxBytes  int64 //maxbytes, split evenly between the shards
+	// nshards is the number of shards, 0 picks it from maxBytes, see defaultShards
+	nshards int
+	// grace is how long the values are kept after they expire, see WithStaleWhileRevalidate
+	grace  time.Duration
+	once   sync.Once
+	shards []*cacheShard
+}
+
+// cacheShard is a part of the cache values, its counters are atomic so stats dont
+// wait for mu, nbytes, nitems and nevict mirror policy after each change
+type cacheShard struct {
+	mu     sync.Mutex // mutual exclusive lock
+	policy EvictionPolicy
+	nget   atomic.Int64
+	nhit   atomic.Int64
+	nbytes atomic.Int64
+	nitems atomic.Int64
+	nevict atomic.Int64
+}
+
+const (
+	// maxShards is the number of shards of a large cache
+	maxShards = 16
+	// minShardBytes is the smallest budget worth a shard, smaller shards would
+	// evict their values while the others still have room
+	minShardBytes = 1 << 20
+)
+
+// defaultShards is the number of shards of a cache of maxBytes, one per
+// minShardBytes up to maxShards
+func defaultShards(maxBytes int64) int {
+	n := maxBytes / minShardBytes
+	if n > maxShards {
+		return maxShards
+	}
+	if n < 1 {
+		return 1
+	}
+	return int(n)
+}
+
+// CacheStats are the statistics of one of the group caches
+type CacheStats struct {
+	Bytes     int64
+	Items     int64
+	Gets      int64
+	Hits      int64
+	Evictions int64
+}
+
+// CacheType represents a type of cache of a Group
+type CacheType int
+
+const (
+	// The MainCache is the cache for items that this peer is the owner for.
+	MainCache CacheType = iota + 1
+
+	// The HotCache is the cache for items that seem popular
+	// enough to replicate to this node, even though it's not the owner.
+	HotCache
+)
+
+// init creates the shards on the first use, after the group options set them up
+func (c *cache) init() {
+	c.once.Do(func() {
+		if c.newPolicy == nil {
+			c.newPolicy = LRU
+		}
+		if c.nshards <= 0 {
+			c.nshards = defaultShards(c.maxBytes)
+		}
+		c.shards = make([]*cacheShard, c.nshards)
+		for i := range c.shards {
+			c.shards[i] = &cacheShard{policy: c.newPolicy(c.maxBytes/int64(c.nshards), nil)}
+		}
+	})
+}
+
+// shard returns the shard of key, picked by the fnv-1a hash of key
+func (c *cache) shard(key string) *cacheShard {
+	c.init()
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return c.shards[h%uint32(len(c.shards))]
 }
 
 // private func accessible within package
-// add and get wrapped lru Add and Get
+// add and get wrapped policy Add and Get
+// the value expires at value.Expire()
 func (c *cache) add(key string, value ByteView) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	// Lazy initialization
-	if c.lru == nil {
-		c.lru = lru.New(c.maxBytes, nil)
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expire := value.Expire()
+	if !expire.IsZero() {
+		expire = expire.Add(c.grace)
 	}
-	c.lru.Add(key, value)
+	s.policy.AddWithExpire(key, value, expire)
+	s.updateStats()
+}
+
+// updateStats mirrors the policy sizes into the atomic counters, mu must be held
+func (s *cacheShard) updateStats() {
+	s.nbytes.Store(s.policy.Bytes())
+	s.nitems.Store(int64(s.policy.Len()))
+	s.nevict.Store(s.policy.Evictions())
 }
 
 func (c *cache) get(key string) (value ByteView, ok bool) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if c.lru == nil {
-		return
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nget.Add(1)
+	// Get removes the expired value
+	defer s.updateStats()
+
+	if value, ok = s.policy.Get(key); ok {
+		s.nhit.Add(1)
 	}
+	return
+}
 
-	if v, ok := c.lru.Get(key); ok {
-		fmt.Printf("lru.Get v %s\n", v)
-		return v.(ByteView), ok
+// removeOldest evicts the value the policy of the largest shard values the least,
+// the group calls it to keep main and hot cache together under its byte budget
+func (c *cache) removeOldest() {
+	c.init()
+	victim := c.shards[0]
+	for _, s := range c.shards[1:] {
+		if s.nbytes.Load() > victim.nbytes.Load() {
+			victim = s
+		}
 	}
+	victim.mu.Lock()
+	defer victim.mu.Unlock()
+	victim.policy.RemoveOldest()
+	victim.updateStats()
+}
 
-	return
+func (c *cache) bytes() int64 {
+	c.init()
+	var n int64
+	for _, s := range c.shards {
+		n += s.nbytes.Load()
+	}
+	return n
+}
+
+// evictions is the number of values evicted from all the shards
+func (c *cache) evictions() int64 {
+	c.init()
+	var n int64
+	for _, s := range c.shards {
+		n += s.nevict.Load()
+	}
+	return n
+}
+
+// stats sums the counters of the shards without locking them
+func (c *cache) stats() CacheStats {
+	c.init()
+	var stats CacheStats
+	for _, s := range c.shards {
+		stats.Bytes += s.nbytes.Load()
+		stats.Items += s.nitems.Load()
+		stats.Gets += s.nget.Load()
+		stats.Hits += s.nhit.Load()
+		stats.Evictions += s.nevict.Load()
+	}
+	return stats
+}
+
+func (c *cache) remove(key string) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policy.Remove(key)
+	s.updateStats()
+}
+
+// removeExpired drops all the expired values, called by the group janitor
+func (c *cache) removeExpired() int {
+	c.init()
+	n := 0
+	for _, s := range c.shards {
+		s.mu.Lock()
+		n += s.policy.RemoveExpired()
+		s.updateStats()
+		s.mu.Unlock()
+	}
+	return n
 }
@@ -0,0 +1,114 @@
+package gocache
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCacheShards(t *testing.T) {
+	if n := defaultShards(2 << 10); n != 1 {
+		t.Fatalf("small cache should have 1 shard, got %d", n)
+	}
+	if n := defaultShards(1 << 30); n != maxShards {
+		t.Fatalf("large cache should have %d shards, got %d", maxShards, n)
+	}
+
+	c := &cache{maxBytes: 4 << 10, nshards: 4}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%02d", i)
+		c.add(key, ByteView{b: []byte("value")})
+	}
+	for i, s := range c.shards {
+		if s.nitems.Load() == 0 {
+			t.Fatalf("shard %d got no keys", i)
+		}
+	}
+	if stats := c.stats(); stats.Items != 100 || stats.Bytes != 100*int64(len("key00")+len("value")) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if v, ok := c.get("key42"); !ok || v.String() != "value" {
+		t.Fatalf("failed to get key42")
+	}
+
+	// the budget is split, a shard evicts once it holds a quarter of it
+	for i := 0; i < 1000; i++ {
+		c.add(strconv.Itoa(i), ByteView{b: make([]byte, 100)})
+	}
+	for i, s := range c.shards {
+		if s.nbytes.Load() > c.maxBytes/4 {
+			t.Fatalf("shard %d holds %d bytes over its budget %d", i, s.nbytes.Load(), c.maxBytes/4)
+		}
+	}
+
+	// removeOldest takes from the largest shard
+	before := c.bytes()
+	largest := int64(0)
+	for _, s := range c.shards {
+		if s.nbytes.Load() > largest {
+			largest = s.nbytes.Load()
+		}
+	}
+	c.removeOldest()
+	if c.bytes() >= before {
+		t.Fatalf("removeOldest removed nothing")
+	}
+	for _, s := range c.shards {
+		if s.nbytes.Load() > largest {
+			t.Fatalf("shard grew over the largest one")
+		}
+	}
+}
+
+// go test -run=^$ -bench=GetParallel -cpu=1,2,4,8 shows the hits per second of
+// one shard and of 16 shards as GOMAXPROCS grows
+func BenchmarkCacheGetParallel(b *testing.B) {
+	const keys = 1024
+	for _, shards := range []int{1, maxShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			c := &cache{maxBytes: 64 << 20, nshards: shards}
+			names := make([]string, keys)
+			for i := range names {
+				names[i] = strconv.Itoa(i)
+				c.add(names[i], ByteView{b: []byte("value")})
+			}
+			var next atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				// each goroutine starts on another key
+				i := int(next.Add(1)) * 97
+				for pb.Next() {
+					c.get(names[i%keys])
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkGroupGetParallel(b *testing.B) {
+	const keys = 1024
+	for _, shards := range []int{1, maxShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			g := NewGroup(fmt.Sprintf("parallel%d", shards), 64<<20, GetterFunc(
+				func(key string) ([]byte, error) {
+					return []byte("value"), nil
+				}), WithShards(shards))
+			names := make([]string, keys)
+			for i := range names {
+				names[i] = strconv.Itoa(i)
+				g.Get(names[i])
+			}
+			var next atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(next.Add(1)) * 97
+				for pb.Next() {
+					g.Get(names[i%keys])
+					i++
+				}
+			})
+		})
+	}
+}
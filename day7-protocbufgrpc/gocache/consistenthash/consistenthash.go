@@ -2,6 +2,7 @@ package consistenthash
 
 import (
 	"hash/crc32"
+	"math"
 	"sort"
 	"strconv"
 )
@@ -9,12 +10,19 @@ import (
 // Hash maps bytes to uint32
 type Hash func(data []byte) uint32
 
+// LoadFunc returns the current load of a node, e.g. its in-flight requests
+type LoadFunc func(node string) int64
+
 // Ring constains all hashed keys
 type HashRing struct {
-	hash     Hash           // custom Hash func
-	replicas int            // virtual and phyical node scale
-	keys     []int          // Sorted virtual node hash values
-	hashMap  map[int]string // vitual node hash value to physical node name
+	hash     Hash             // custom Hash func
+	replicas int              // virtual and phyical node scale
+	keys     []int            // Sorted unique virtual node hash values
+	hashMap  map[int][]string // vitual node hash value to the sorted physical nodes claiming it, the first owns it
+	weights  map[string]int   // physical node to weight, it has replicas * weight virtual nodes
+	// bounded loads, off while load is nil
+	epsilon float64
+	load    LoadFunc
 }
 
 // New creates a Map instance
@@ -22,7 +30,8 @@ func New(replicas int, hash Hash) *HashRing {
 	ring := &HashRing{
 		hash:     hash,
 		replicas: replicas,
-		hashMap:  make(map[int]string),
+		hashMap:  make(map[int][]string),
+		weights:  make(map[string]int),
 	}
 	// default Hash func
 	if ring.hash == nil {
@@ -33,18 +42,70 @@ func New(replicas int, hash Hash) *HashRing {
 
 // add vitual nodes to ring based on physical node/key
 // each node corresponds to these virtiual nodes strconv.Itoa(i) + node
+// nodes already in the ring are left as they are
 func (ring *HashRing) Add(nodes ...string) {
 	for _, node := range nodes {
-		for i := 0; i < ring.replicas; i++ {
-			vHash := int(ring.hash([]byte(strconv.Itoa(i) + node)))
-			ring.hashMap[vHash] = node
-			ring.keys = append(ring.keys, vHash)
+		if _, ok := ring.weights[node]; !ok {
+			ring.add(node, 1)
 		}
 	}
 	// sort keys
 	sort.Ints(ring.keys)
 }
 
+// AddWeighted adds node with weight times the virtual nodes of Add, so it owns
+// about weight times the keys, e.g. the GB of memory of the node.
+// The weight of a node already in the ring is changed in place.
+func (ring *HashRing) AddWeighted(node string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	if ring.weights[node] == weight {
+		return
+	}
+	ring.Remove(node)
+	ring.add(node, weight)
+	sort.Ints(ring.keys)
+}
+
+// add appends the virtual nodes of node without sorting keys.
+// When virtual nodes of different nodes collide the smallest node name owns the
+// hash, so the ring only depends on its nodes and not on the order they came in,
+// and the others take it over if it's removed.
+func (ring *HashRing) add(node string, weight int) {
+	ring.weights[node] = weight
+	for i := 0; i < ring.replicas*weight; i++ {
+		vHash := ring.vnodeHash(i, node)
+		claims, ok := ring.hashMap[vHash]
+		if !ok {
+			ring.keys = append(ring.keys, vHash)
+		}
+		idx := sort.SearchStrings(claims, node)
+		claims = append(claims, "")
+		copy(claims[idx+1:], claims[idx:])
+		claims[idx] = node
+		ring.hashMap[vHash] = claims
+	}
+}
+
+func (ring *HashRing) vnodeHash(i int, node string) int {
+	return int(ring.hash([]byte(strconv.Itoa(i) + node)))
+}
+
+// Weight returns the weight of node, 0 if it's not in the ring
+func (ring *HashRing) Weight(node string) int {
+	return ring.weights[node]
+}
+
+// SetBoundedLoad turns on consistent hashing with bounded loads (Mirrokni, Thorup
+// & Zadimoghaddam, https://arxiv.org/abs/1608.01350). Get walks clockwise past the
+// nodes whose load would go over (1+epsilon) times the average load, in proportion
+// to their weight, so hot keys spill over to the next nodes. A nil load turns it off.
+func (ring *HashRing) SetBoundedLoad(epsilon float64, load LoadFunc) {
+	ring.epsilon = epsilon
+	ring.load = load
+}
+
 // get physical node of the key
 // binary search find the node which hash value first >= key hash value
 func (ring *HashRing) Get(key string) string {
@@ -57,17 +118,84 @@ func (ring *HashRing) Get(key string) string {
 		return ring.keys[i] >= keyHash
 	})
 	// in case nodeIdx == len(keys) take mod
-	return ring.hashMap[ring.keys[nodeIdx%len(ring.keys)]]
+	owner := ring.hashMap[ring.keys[nodeIdx%len(ring.keys)]][0]
+	if ring.load == nil {
+		return owner
+	}
+	var total int64
+	totalWeight := 0
+	for node, weight := range ring.weights {
+		total += ring.load(node)
+		totalWeight += weight
+	}
+	for i := 0; i < len(ring.keys); i++ {
+		node := ring.hashMap[ring.keys[(nodeIdx+i)%len(ring.keys)]][0]
+		// the bound counts the request being placed
+		bound := math.Ceil((1 + ring.epsilon) * float64(total+1) * float64(ring.weights[node]) / float64(totalWeight))
+		if float64(ring.load(node)+1) <= bound {
+			return node
+		}
+	}
+	return owner
+}
+
+// GetN returns up to n distinct physical nodes for key, the owner first then its
+// successors clockwise, they take over the key in this order when the owner leaves
+func (ring *HashRing) GetN(key string, n int) []string {
+	if len(ring.keys) == 0 || n <= 0 {
+		return nil
+	}
+	if n > len(ring.weights) {
+		n = len(ring.weights)
+	}
+	keyHash := int(ring.hash([]byte(key)))
+	nodeIdx := sort.Search(len(ring.keys), func(i int) bool {
+		return ring.keys[i] >= keyHash
+	})
+	nodes := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	for i := 0; i < len(ring.keys) && len(nodes) < n; i++ {
+		for _, node := range ring.hashMap[ring.keys[(nodeIdx+i)%len(ring.keys)]] {
+			if !seen[node] && len(nodes) < n {
+				seen[node] = true
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	return nodes
 }
 
 // remove physical node, we dont need to sort again as it's sorted in Add()
+// a virtual node hash also claimed by other nodes stays on the ring for them
 func (ring *HashRing) Remove(key string) {
-	for i := 0; i < ring.replicas; i++ {
-		virtualHash := int(ring.hash([]byte(strconv.Itoa(i) + key)))
-		// remove it from keys, first find the idx of the key
-		virtualIdx := sort.SearchInts(ring.keys, virtualHash)
-		ring.keys = append(ring.keys[:virtualIdx], ring.keys[virtualIdx+1:]...)
-		// remove from hashmap
+	weight, ok := ring.weights[key]
+	if !ok {
+		return
+	}
+	delete(ring.weights, key)
+	freed := make(map[int]bool)
+	for i := 0; i < ring.replicas*weight; i++ {
+		virtualHash := ring.vnodeHash(i, key)
+		claims := ring.hashMap[virtualHash]
+		// the node claims the hash once per virtual node colliding on it
+		idx := sort.SearchStrings(claims, key)
+		if idx == len(claims) || claims[idx] != key {
+			continue
+		}
+		claims = append(claims[:idx], claims[idx+1:]...)
+		if len(claims) > 0 {
+			ring.hashMap[virtualHash] = claims
+			continue
+		}
+		// remove from hashmap, and from keys below
 		delete(ring.hashMap, virtualHash)
+		freed[virtualHash] = true
+	}
+	keys := ring.keys[:0]
+	for _, vHash := range ring.keys {
+		if !freed[vHash] {
+			keys = append(keys, vHash)
+		}
 	}
+	ring.keys = keys
 }
@@ -1,8 +1,13 @@
 package consistenthash
 
 import (
+	"fmt"
+	"hash/crc32"
+	"reflect"
+	"sort"
 	"strconv"
 	"testing"
+	"testing/quick"
 )
 
 func TestHashing(t *testing.T) {
@@ -54,3 +59,182 @@ func TestHashing(t *testing.T) {
 	}
 
 }
+
+func TestAddWeighted(t *testing.T) {
+	ring := New(50, nil)
+	ring.Add("small")
+	ring.AddWeighted("big", 8)
+
+	owned := map[string]int{}
+	for i := 0; i < 90000; i++ {
+		owned[ring.Get(strconv.Itoa(i))]++
+	}
+	// big owns about 8/9 of the keys
+	if share := float64(owned["big"]) / 90000; share < 0.8 || share > 0.95 {
+		t.Fatalf("big owns %.2f of the keys, expect about 0.89", share)
+	}
+
+	// reweighting in place then removing leaves the ring of small only
+	ring.AddWeighted("big", 2)
+	if ring.Weight("big") != 2 || len(ring.keys) != 50*3 {
+		t.Fatalf("reweight big to 2, got weight %d and %d virtual nodes", ring.Weight("big"), len(ring.keys))
+	}
+	ring.Remove("big")
+	ring.Remove("unknown")
+	if len(ring.keys) != 50 || len(ring.hashMap) != 50 || ring.Get("1") != "small" {
+		t.Fatalf("remove big left %d virtual nodes", len(ring.keys))
+	}
+}
+
+func TestBoundedLoad(t *testing.T) {
+	ring := New(50, nil)
+	ring.Add("a", "b", "c")
+	loads := map[string]int64{}
+	ring.SetBoundedLoad(0.25, func(node string) int64 { return loads[node] })
+
+	// every request asks for the same hot key and stays in flight
+	owner := ring.Get("hot")
+	for i := 0; i < 300; i++ {
+		loads[ring.Get("hot")]++
+	}
+	// no node goes over ceil(1.25 * 300 / 3)
+	for node, load := range loads {
+		if load > 125 {
+			t.Fatalf("%s has load %d over the bound 125", node, load)
+		}
+	}
+	if loads[owner] != 125 || len(loads) != 3 {
+		t.Fatalf("owner %s should be filled to the bound before spilling, loads %v", owner, loads)
+	}
+
+	// the owner takes the key again once it's not busy
+	loads = map[string]int64{}
+	ring.SetBoundedLoad(0.25, func(node string) int64 { return loads[node] })
+	if ring.Get("hot") != owner {
+		t.Fatalf("idle ring should pick the owner %s", owner)
+	}
+}
+
+// op is a random membership operation for the property tests
+type op struct {
+	Node   uint8 // one of 8 nodes, some of them collide on purpose
+	Weight uint8 // 0 removes the node, 1 adds it, more adds it weighted
+}
+
+var opNodes = []string{"a", "1a", "b", "11a", "c", "2b", "d", "12b"}
+
+// collidingHash puts the virtual nodes on 64 positions only, so they collide a lot
+func collidingHash(data []byte) uint32 {
+	return crc32.ChecksumIEEE(data) % 64
+}
+
+// apply runs ops on a fresh ring and returns it with the expected weights
+func apply(ops []op, hash Hash) (*HashRing, map[string]int) {
+	ring := New(3, hash)
+	weights := map[string]int{}
+	for _, o := range ops {
+		node := opNodes[int(o.Node)%len(opNodes)]
+		switch weight := int(o.Weight % 4); weight {
+		case 0:
+			ring.Remove(node)
+			delete(weights, node)
+		case 1:
+			ring.Add(node)
+			if _, ok := weights[node]; !ok {
+				weights[node] = 1
+			}
+		default:
+			ring.AddWeighted(node, weight)
+			weights[node] = weight
+		}
+	}
+	return ring, weights
+}
+
+// checkRing checks the invariants of the ring holding the nodes of weights
+func checkRing(ring *HashRing, weights map[string]int) error {
+	if !reflect.DeepEqual(ring.weights, weights) {
+		return fmt.Errorf("ring has weights %v, expect %v", ring.weights, weights)
+	}
+	if len(ring.keys) != len(ring.hashMap) {
+		return fmt.Errorf("%d keys for %d hashes", len(ring.keys), len(ring.hashMap))
+	}
+	claims := 0
+	for i, vHash := range ring.keys {
+		if i > 0 && ring.keys[i-1] >= vHash {
+			return fmt.Errorf("keys are not sorted and unique: %v", ring.keys)
+		}
+		nodes := ring.hashMap[vHash]
+		if len(nodes) == 0 || !sort.StringsAreSorted(nodes) {
+			return fmt.Errorf("hash %d is claimed by %v", vHash, nodes)
+		}
+		claims += len(nodes)
+	}
+	expect := 0
+	for _, weight := range weights {
+		expect += ring.replicas * weight
+	}
+	if claims != expect {
+		return fmt.Errorf("%d virtual nodes, expect %d", claims, expect)
+	}
+	for i := 0; i < 100; i++ {
+		owner := ring.Get(strconv.Itoa(i))
+		if _, ok := weights[owner]; !ok && (owner != "" || len(weights) > 0) {
+			return fmt.Errorf("key %d is owned by %q which is not in the ring", i, owner)
+		}
+	}
+	return nil
+}
+
+// any sequence of Add, AddWeighted and Remove leaves a consistent ring, which
+// places keys the same as a ring built from scratch with the same nodes in another order
+func TestRingProperties(t *testing.T) {
+	for _, hash := range []Hash{nil, collidingHash} {
+		property := func(ops []op) bool {
+			ring, weights := apply(ops, hash)
+			if err := checkRing(ring, weights); err != nil {
+				t.Log(err)
+				return false
+			}
+			nodes := make([]string, 0, len(weights))
+			for node := range weights {
+				nodes = append(nodes, node)
+			}
+			sort.Sort(sort.Reverse(sort.StringSlice(nodes)))
+			fresh := New(3, hash)
+			for _, node := range nodes {
+				fresh.AddWeighted(node, weights[node])
+				// adding twice changes nothing
+				fresh.Add(node)
+			}
+			for i := 0; i < 100; i++ {
+				if key := strconv.Itoa(i); ring.Get(key) != fresh.Get(key) {
+					t.Logf("key %s is on %s, and on %s in a fresh ring", key, ring.Get(key), fresh.Get(key))
+					return false
+				}
+			}
+			return true
+		}
+		if err := quick.Check(property, &quick.Config{MaxCount: 500}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// the same hash input from two nodes, "1" + "1a" and "11" + "a"
+func TestCollision(t *testing.T) {
+	ring := New(12, nil)
+	ring.Add("a", "1a")
+	vHash := ring.vnodeHash(11, "a")
+	if owners := ring.hashMap[vHash]; !reflect.DeepEqual(owners, []string{"1a", "a"}) {
+		t.Fatalf("colliding hash claimed by %v", owners)
+	}
+	ring.Remove("1a")
+	if owners := ring.hashMap[vHash]; !reflect.DeepEqual(owners, []string{"a"}) {
+		t.Fatalf("a should keep the colliding hash, got %v", owners)
+	}
+	ring.Remove("a")
+	if len(ring.keys) != 0 || len(ring.hashMap) != 0 || ring.Get("key") != "" {
+		t.Fatalf("empty ring has %d keys", len(ring.keys))
+	}
+}
@@ -0,0 +1,242 @@
+package consistenthash
+
+import (
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+// Placement maps keys to nodes, the pools pick the peer owning a key with it.
+// HashRing, Rendezvous and Jump implement it.
+type Placement interface {
+	// AddWeighted adds node owning about weight times the keys of a node of weight 1,
+	// the weight of a node already there is changed
+	AddWeighted(node string, weight int)
+	// Remove removes node, the other nodes keep their keys
+	Remove(node string)
+	// Get returns the node owning key, "" without nodes
+	Get(key string) string
+	// GetN returns up to n distinct nodes for key, the owner first, the next
+	// ones own it in this order if the previous ones are removed
+	GetN(key string, n int) []string
+	// Weight returns the weight of node, 0 if it's not there
+	Weight(node string) int
+}
+
+var (
+	_ Placement = (*HashRing)(nil)
+	_ Placement = (*Rendezvous)(nil)
+	_ Placement = (*Jump)(nil)
+)
+
+// hash64 is the 64 bits fnv-1a of s, the placements below need more bits than Hash
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// mix64 is the finalizer of murmur3, it spreads the bits of fnv
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// Rendezvous is highest random weight hashing (Thaler & Ravishankar): every node
+// scores the key and the highest score owns it. There are no virtual nodes so keys
+// spread evenly, and only the keys of an added or removed node move, but Get is
+// O(nodes).
+type Rendezvous struct {
+	nodes []rendezvousNode // sorted by name so ties break the same way everywhere
+}
+
+type rendezvousNode struct {
+	name   string
+	hash   uint64
+	weight int
+}
+
+// NewRendezvous creates an empty Rendezvous
+func NewRendezvous() *Rendezvous {
+	return &Rendezvous{}
+}
+
+// AddWeighted adds node, see Placement
+func (r *Rendezvous) AddWeighted(node string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	i := sort.Search(len(r.nodes), func(i int) bool { return r.nodes[i].name >= node })
+	if i < len(r.nodes) && r.nodes[i].name == node {
+		r.nodes[i].weight = weight
+		return
+	}
+	r.nodes = append(r.nodes, rendezvousNode{})
+	copy(r.nodes[i+1:], r.nodes[i:])
+	r.nodes[i] = rendezvousNode{name: node, hash: hash64(node), weight: weight}
+}
+
+// Remove removes node, see Placement
+func (r *Rendezvous) Remove(node string) {
+	i := sort.Search(len(r.nodes), func(i int) bool { return r.nodes[i].name >= node })
+	if i < len(r.nodes) && r.nodes[i].name == node {
+		r.nodes = append(r.nodes[:i], r.nodes[i+1:]...)
+	}
+}
+
+// Get returns the node with the highest score for key, weighted nodes score
+// -weight / ln(u) for a uniform u in (0, 1) as in weighted rendezvous hashing
+func (r *Rendezvous) Get(key string) string {
+	keyHash := hash64(key)
+	owner := ""
+	best := math.Inf(-1)
+	for _, node := range r.nodes {
+		if score := r.score(keyHash, node); score > best {
+			owner, best = node.name, score
+		}
+	}
+	return owner
+}
+
+// GetN returns the n nodes with the highest scores for key
+func (r *Rendezvous) GetN(key string, n int) []string {
+	if n > len(r.nodes) {
+		n = len(r.nodes)
+	}
+	if n <= 0 {
+		return nil
+	}
+	keyHash := hash64(key)
+	type scored struct {
+		name  string
+		score float64
+	}
+	scores := make([]scored, len(r.nodes))
+	for i, node := range r.nodes {
+		scores[i] = scored{node.name, r.score(keyHash, node)}
+	}
+	sort.SliceStable(scores, func(i, j int) bool { return scores[i].score > scores[j].score })
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = scores[i].name
+	}
+	return nodes
+}
+
+// score is the weighted score of node for the key hash
+func (r *Rendezvous) score(keyHash uint64, node rendezvousNode) float64 {
+	// 53 bits for a float in (0, 1)
+	u := (float64(mix64(keyHash^node.hash)>>11) + 0.5) / (1 << 53)
+	return -float64(node.weight) / math.Log(u)
+}
+
+// Weight returns the weight of node, see Placement
+func (r *Rendezvous) Weight(node string) int {
+	i := sort.Search(len(r.nodes), func(i int) bool { return r.nodes[i].name >= node })
+	if i < len(r.nodes) && r.nodes[i].name == node {
+		return r.nodes[i].weight
+	}
+	return 0
+}
+
+// Jump is jump consistent hashing (Lamping & Veach, https://arxiv.org/abs/1406.2294),
+// it maps keys to buckets 0..n-1 in O(log n) without memory, a node of weight w
+// has w buckets. The buckets are given to the nodes in the order of their names,
+// so every process with the same nodes agrees on the owners whatever order they
+// were added in. Jump only moves the keys of the last buckets when they're removed,
+// so adding or removing the node sorted last moves its keys only, any other node
+// shifts the buckets after its own and their keys move too.
+type Jump struct {
+	buckets []string // bucket to node
+	weights map[string]int
+}
+
+// NewJump creates an empty Jump
+func NewJump() *Jump {
+	return &Jump{weights: make(map[string]int)}
+}
+
+// AddWeighted adds node, see Placement
+func (j *Jump) AddWeighted(node string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	if j.weights[node] == weight {
+		return
+	}
+	j.weights[node] = weight
+	j.rebuild()
+}
+
+// Remove removes node, see Placement
+func (j *Jump) Remove(node string) {
+	if _, ok := j.weights[node]; !ok {
+		return
+	}
+	delete(j.weights, node)
+	j.rebuild()
+}
+
+// rebuild gives the buckets to the nodes sorted by name
+func (j *Jump) rebuild() {
+	nodes := make([]string, 0, len(j.weights))
+	for node := range j.weights {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	j.buckets = j.buckets[:0]
+	for _, node := range nodes {
+		for i := 0; i < j.weights[node]; i++ {
+			j.buckets = append(j.buckets, node)
+		}
+	}
+}
+
+// Get returns the node of the bucket of key
+func (j *Jump) Get(key string) string {
+	if len(j.buckets) == 0 {
+		return ""
+	}
+	return j.buckets[jumpHash(mix64(hash64(key)), len(j.buckets))]
+}
+
+// GetN returns the nodes of the bucket of key and of the next buckets, jump has
+// no successors so they are only stable while the node set doesnt change
+func (j *Jump) GetN(key string, n int) []string {
+	if n > len(j.weights) {
+		n = len(j.weights)
+	}
+	if n <= 0 {
+		return nil
+	}
+	b := jumpHash(mix64(hash64(key)), len(j.buckets))
+	nodes := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	for i := 0; len(nodes) < n; i++ {
+		if node := j.buckets[(b+i)%len(j.buckets)]; !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// Weight returns the weight of node, see Placement
+func (j *Jump) Weight(node string) int {
+	return j.weights[node]
+}
+
+// jumpHash is the algorithm of the paper, key to a bucket in [0, buckets)
+func jumpHash(key uint64, buckets int) int {
+	var b, next int64 = -1, 0
+	for next < int64(buckets) {
+		b = next
+		key = key*2862933555777941757 + 1
+		next = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
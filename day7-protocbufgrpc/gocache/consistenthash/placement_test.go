@@ -0,0 +1,181 @@
+package consistenthash
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+var placements = []struct {
+	name string
+	new  func() Placement
+}{
+	{"ring3", func() Placement { return New(3, nil) }},
+	{"ring100", func() Placement { return New(100, nil) }},
+	{"rendezvous", func() Placement { return NewRendezvous() }},
+	{"jump", func() Placement { return NewJump() }},
+}
+
+func newPlacement(new func() Placement, nodes int) Placement {
+	placement := new()
+	for i := 0; i < nodes; i++ {
+		placement.AddWeighted(fmt.Sprintf("node%d", i), 1)
+	}
+	return placement
+}
+
+// owners returns the owner of each of keys keys
+func owners(placement Placement, keys int) []string {
+	owner := make([]string, keys)
+	for i := range owner {
+		owner[i] = placement.Get(strconv.Itoa(i))
+	}
+	return owner
+}
+
+// moved returns the share of keys changing owner
+func moved(before, after []string) float64 {
+	n := 0
+	for i := range before {
+		if before[i] != after[i] {
+			n++
+		}
+	}
+	return float64(n) / float64(len(before))
+}
+
+// maxLoad returns the keys of the busiest node over the mean
+func maxLoad(owner []string, nodes int) float64 {
+	counts := map[string]int{}
+	max := 0
+	for _, node := range owner {
+		if counts[node]++; counts[node] > max {
+			max = counts[node]
+		}
+	}
+	return float64(max) * float64(nodes) / float64(len(owner))
+}
+
+func TestPlacements(t *testing.T) {
+	for _, p := range placements {
+		t.Run(p.name, func(t *testing.T) {
+			if p.new().Get("key") != "" {
+				t.Fatalf("empty placement should return no node")
+			}
+			placement := newPlacement(p.new, 5)
+			before := owners(placement, 10000)
+
+			// only the keys of the removed node move, except jump which also
+			// moves the keys of the nodes sorted after it into the freed buckets
+			placement.Remove("node2")
+			placement.Remove("unknown")
+			after := owners(placement, 10000)
+			for i := range before {
+				if after[i] == "node2" {
+					t.Fatalf("removed node2 still owns %d", i)
+				}
+				if before[i] != after[i] && before[i] != "node2" && (p.name != "jump" || before[i] < "node2") {
+					t.Fatalf("key %d moved from %s to %s", i, before[i], after[i])
+				}
+			}
+
+			// a node of weight 4 owns about 4/8 of the keys
+			placement.AddWeighted("big", 4)
+			if placement.Weight("big") != 4 || placement.Weight("node2") != 0 {
+				t.Fatalf("weights of big %d and node2 %d", placement.Weight("big"), placement.Weight("node2"))
+			}
+			if load := maxLoad(owners(placement, 10000), 8) / 4; load < 0.7 || load > 1.3 {
+				t.Fatalf("big owns %.2f of its fair share", load)
+			}
+		})
+	}
+}
+
+// the owners only depend on the nodes, not on the order they were added and
+// removed in, so the peers agree on them
+func TestPlacementOrder(t *testing.T) {
+	for _, p := range placements {
+		t.Run(p.name, func(t *testing.T) {
+			a := p.new()
+			for i := 0; i < 5; i++ {
+				a.AddWeighted(fmt.Sprintf("node%d", i), i+1)
+			}
+			b := p.new()
+			b.AddWeighted("gone", 2)
+			for i := 4; i >= 0; i-- {
+				b.AddWeighted(fmt.Sprintf("node%d", i), i+1)
+			}
+			b.Remove("gone")
+			before, after := owners(a, 10000), owners(b, 10000)
+			if m := moved(before, after); m != 0 {
+				t.Fatalf("%.1f%% of the keys have another owner", m*100)
+			}
+		})
+	}
+}
+
+func TestGetN(t *testing.T) {
+	for _, p := range placements {
+		t.Run(p.name, func(t *testing.T) {
+			if nodes := p.new().GetN("key", 3); len(nodes) != 0 {
+				t.Fatalf("empty placement should return no nodes, got %v", nodes)
+			}
+			placement := newPlacement(p.new, 5)
+			if nodes := placement.GetN("key", 10); len(nodes) != 5 {
+				t.Fatalf("expect the 5 nodes, got %v", nodes)
+			}
+			for i := 0; i < 1000; i++ {
+				key := strconv.Itoa(i)
+				nodes := placement.GetN(key, 3)
+				if len(nodes) != 3 || nodes[0] != placement.Get(key) {
+					t.Fatalf("GetN(%s) = %v, owner %s", key, nodes, placement.Get(key))
+				}
+				if nodes[0] == nodes[1] || nodes[1] == nodes[2] || nodes[0] == nodes[2] {
+					t.Fatalf("GetN(%s) = %v has duplicates", key, nodes)
+				}
+			}
+			// the successor takes over the keys of a removed owner, jump moves
+			// buckets around so it only keeps the first guarantees
+			if p.name == "jump" {
+				return
+			}
+			before := make([][]string, 1000)
+			for i := range before {
+				before[i] = placement.GetN(strconv.Itoa(i), 2)
+			}
+			placement.Remove("node2")
+			for i, nodes := range before {
+				if nodes[0] == "node2" && placement.Get(strconv.Itoa(i)) != nodes[1] {
+					t.Fatalf("key %d moved to %s instead of its replica %s", i, placement.Get(strconv.Itoa(i)), nodes[1])
+				}
+			}
+		})
+	}
+}
+
+// go test -run=^$ -bench=Placement ./consistenthash reports for 10 nodes the keys of
+// the busiest node over the mean and the % of keys moving when a node joins or leaves,
+// the ideal is 1 maxload and 9.1% / 10% moved
+func BenchmarkPlacement(b *testing.B) {
+	const nodes, keys = 10, 100000
+	for _, p := range placements {
+		b.Run(p.name, func(b *testing.B) {
+			placement := newPlacement(p.new, nodes)
+			before := owners(placement, keys)
+			placement.AddWeighted("new", 1)
+			join := moved(before, owners(placement, keys))
+			placement.Remove("new")
+			placement.Remove("node3")
+			leave := moved(before, owners(placement, keys))
+			placement.AddWeighted("node3", 1)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				placement.Get(strconv.Itoa(i))
+			}
+			b.ReportMetric(maxLoad(before, nodes), "maxload")
+			b.ReportMetric(join*100, "%moved-join")
+			b.ReportMetric(leave*100, "%moved-leave")
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package gocache
+
+import (
+	"context"
+	"fmt"
+	"gocache/bloom"
+	"io"
+	"sync"
+	"time"
+)
+
+// A KeyLoader lists all the keys which exist in the origin, e.g. with a scan of
+// the table, to build the key filter of a group, see FilterPolicy.
+type KeyLoader interface {
+	LoadKeys(ctx context.Context, add func(key string)) error
+}
+
+// A KeyLoaderFunc implements KeyLoader with a function.
+type KeyLoaderFunc func(ctx context.Context, add func(key string)) error
+
+// LoadKeys implements KeyLoader interface function
+func (f KeyLoaderFunc) LoadKeys(ctx context.Context, add func(key string)) error {
+	return f(ctx, add)
+}
+
+// FilterPolicy is the Bloom filter of the keys known to exist, see WithKeyFilter
+type FilterPolicy struct {
+	// Keys is how many keys the filter is sized for, defaultFilterKeys when 0.
+	// A rebuild sizes the new filter for the keys of the old one if there are more.
+	Keys int
+	// FalsePositive is the share of the unknown keys let through, 0.01 when 0
+	FalsePositive float64
+	// Loader fills the filter, until it's done the first time all the keys are let
+	// through. Without it the filter is filled by AddKeys, all the keys are let
+	// through until the first AddKeys or RestoreFilter.
+	Loader KeyLoader
+	// Rebuild is how often Loader fills a new filter, so the keys deleted from
+	// the origin are forgotten and the false positives stay low. 0 loads it once.
+	Rebuild time.Duration
+}
+
+const (
+	// defaultFilterKeys takes 1.2MB at 1% false positives
+	defaultFilterKeys    = 1 << 20
+	defaultFalsePositive = 0.01
+	// filterLoadTimeout bounds a run of the Loader in background
+	filterLoadTimeout = time.Minute
+)
+
+// WithKeyFilter rejects the Gets of keys which dont pass a Bloom filter of the
+// keys known to exist with ErrNotFound, before the peers and the Getter are asked,
+// so a flood of random keys costs a hash each. The filter knows the keys of its
+// Loader, of AddKeys and of Set, and the keys this node loaded while the filter
+// was not filled yet. Each node has its own filter, a key created through another
+// node is known here after the next rebuild, or AddKeys.
+func WithKeyFilter(policy FilterPolicy) GroupOption {
+	return func(g *Group) {
+		if policy.Keys <= 0 {
+			policy.Keys = defaultFilterKeys
+		}
+		if policy.FalsePositive <= 0 {
+			policy.FalsePositive = defaultFalsePositive
+		}
+		g.filter = &keyFilter{
+			policy: policy,
+			bloom:  bloom.New(policy.Keys, policy.FalsePositive),
+		}
+	}
+}
+
+// keyFilter is the Bloom filter of a group, next is the filter being rebuilt,
+// the keys added meanwhile go to both
+type keyFilter struct {
+	policy FilterPolicy
+	// rebuilding makes the rebuilds run one at a time
+	rebuilding sync.Mutex
+	mu         sync.RWMutex
+	bloom      *bloom.Filter
+	next       *bloom.Filter
+	// ready is false until the Loader, or AddKeys without a Loader, filled the filter
+	ready bool
+}
+
+// add adds keys, filled is true when they fill the filter, see FilterPolicy.Loader
+func (f *keyFilter) add(filled bool, keys ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, key := range keys {
+		f.bloom.Add(key)
+		if f.next != nil {
+			f.next.Add(key)
+		}
+	}
+	if filled && f.policy.Loader == nil {
+		f.ready = true
+	}
+}
+
+// mayExist reports whether key passes the filter
+func (f *keyFilter) mayExist(key string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return !f.ready || f.bloom.Test(key)
+}
+
+// rebuild fills a new filter with the Loader and swaps it in, the old filter is
+// kept if the Loader fails
+func (f *keyFilter) rebuild(ctx context.Context) error {
+	f.rebuilding.Lock()
+	defer f.rebuilding.Unlock()
+	f.mu.Lock()
+	keys := f.policy.Keys
+	if n := f.bloom.Len(); n > keys {
+		keys = n
+	}
+	f.next = bloom.New(keys, f.policy.FalsePositive)
+	f.mu.Unlock()
+
+	err := f.policy.Loader.LoadKeys(ctx, func(key string) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.next.Add(key)
+	})
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		f.bloom, f.ready = f.next, true
+	}
+	f.next = nil
+	return err
+}
+
+// rebuilder fills the filter in background right away, then every Rebuild until
+// the group is closed, which cancels the Loader too
+func (g *Group) rebuilder() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-g.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	rebuild := func() {
+		loadCtx, cancel := context.WithTimeout(ctx, filterLoadTimeout)
+		defer cancel()
+		if err := g.filter.rebuild(loadCtx); err != nil && ctx.Err() == nil {
+			g.stats.filterErrors.Add(1)
+		}
+	}
+	rebuild()
+	if g.filter.policy.Rebuild <= 0 {
+		return
+	}
+	ticker := time.NewTicker(g.filter.policy.Rebuild)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			rebuild()
+		case <-g.closed:
+			return
+		}
+	}
+}
+
+// mayExist reports whether key passes the key filter, true without one
+func (g *Group) mayExist(key string) bool {
+	if g.filter == nil || g.filter.mayExist(key) {
+		return true
+	}
+	g.stats.filterRejects.Add(1)
+	return false
+}
+
+// addKey adds key to the key filter if there is one
+func (g *Group) addKey(key string) {
+	if g.filter != nil {
+		g.filter.add(false, key)
+	}
+}
+
+// AddKeys adds keys to the key filter, e.g. all of them at start without a Loader,
+// or when they're created in the origin so they are not rejected until the next
+// rebuild. Without WithKeyFilter it does nothing.
+func (g *Group) AddKeys(keys ...string) {
+	if g.filter != nil {
+		g.filter.add(true, keys...)
+	}
+}
+
+// RebuildFilter fills a new key filter with the Loader now, the filter is kept
+// if the Loader fails
+func (g *Group) RebuildFilter(ctx context.Context) error {
+	if g.filter == nil || g.filter.policy.Loader == nil {
+		return fmt.Errorf("group %s has no key filter loader", g.name)
+	}
+	err := g.filter.rebuild(ctx)
+	if err != nil {
+		g.stats.filterErrors.Add(1)
+	}
+	return err
+}
+
+// SnapshotFilter writes the key filter to w, RestoreFilter reads it back, e.g. so
+// a node restarts with the filter of before instead of waiting for the Loader
+func (g *Group) SnapshotFilter(w io.Writer) error {
+	if g.filter == nil {
+		return fmt.Errorf("group %s has no key filter", g.name)
+	}
+	g.filter.mu.RLock()
+	defer g.filter.mu.RUnlock()
+	_, err := g.filter.bloom.WriteTo(w)
+	return err
+}
+
+// RestoreFilter replaces the key filter with a snapshot of SnapshotFilter, the
+// filter is ready even if the Loader didnt fill it yet
+func (g *Group) RestoreFilter(r io.Reader) error {
+	if g.filter == nil {
+		return fmt.Errorf("group %s has no key filter", g.name)
+	}
+	restored := &bloom.Filter{}
+	if _, err := restored.ReadFrom(r); err != nil {
+		return err
+	}
+	g.filter.mu.Lock()
+	defer g.filter.mu.Unlock()
+	g.filter.bloom, g.filter.ready = restored, true
+	return nil
+}
@@ -0,0 +1,158 @@
+package gocache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyFilter(t *testing.T) {
+	loads := 0
+	g := NewGroup("filter", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		if v, ok := db[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, ErrNotFound
+	}), WithKeyFilter(FilterPolicy{Keys: 100}))
+	defer g.Close()
+
+	// without a Loader all the keys pass until AddKeys, the keys loaded meanwhile are added
+	if v, err := g.Get("Tom"); err != nil || v.String() != "630" || loads != 1 {
+		t.Fatalf("empty filter should let Tom through, got %q %v", v.String(), err)
+	}
+	g.AddKeys("Jack")
+	if _, err := g.Get("Sam"); !errors.Is(err, ErrNotFound) || loads != 1 {
+		t.Fatalf("unknown key should be rejected before the getter, got %v and %d loads", err, loads)
+	}
+	if v, err := g.Get("Jack"); err != nil || v.String() != "589" || loads != 2 {
+		t.Fatalf("added key should be loaded, got %q %v", v.String(), err)
+	}
+	g.Remove(context.Background(), "Tom")
+	if _, err := g.Get("Tom"); err != nil || loads != 3 {
+		t.Fatalf("loaded key should pass the filter, got %v and %d loads", err, loads)
+	}
+	// Set adds the key
+	g.Set(context.Background(), "new", []byte("1"))
+	g.Remove(context.Background(), "new")
+	if g.Get("new"); loads != 4 {
+		t.Fatalf("key set should pass the filter, %d loads", loads)
+	}
+
+	values, err := g.GetMany(context.Background(), []string{"Tom", "Sam"})
+	if !errors.Is(err, ErrNotFound) || len(values) != 1 {
+		t.Fatalf("GetMany should reject Sam, got %v %v", values, err)
+	}
+	if g.Stats().FilterRejects != 2 {
+		t.Fatalf("expect 2 rejects, got %+v", g.Stats())
+	}
+}
+
+func TestKeyFilterLoader(t *testing.T) {
+	var mu sync.Mutex
+	keys := []string{"Tom"}
+	loaded := make(chan struct{}, 10)
+	loader := KeyLoaderFunc(func(ctx context.Context, add func(key string)) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, key := range keys {
+			add(key)
+		}
+		loaded <- struct{}{}
+		return nil
+	})
+	g := NewGroup("filter-loader", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithKeyFilter(FilterPolicy{Loader: loader, Rebuild: 20 * time.Millisecond}))
+	waitRebuilt(g, loaded)
+
+	if _, err := g.Get("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Get("Jack"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Jack is not loaded yet, got %v", err)
+	}
+	// the rebuild knows the new keys and forgets the deleted ones
+	mu.Lock()
+	keys = []string{"Jack"}
+	mu.Unlock()
+	<-loaded
+	waitRebuilt(g, loaded)
+	if _, err := g.Get("Jack"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Get("Sam"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Sam was never loaded, got %v", err)
+	}
+
+	// closing the group stops the rebuilds, one may be running already
+	g.Close()
+	for len(loaded) > 0 {
+		<-loaded
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(loaded) > 1 {
+		t.Fatalf("filter rebuilt %d times after Close", len(loaded))
+	}
+
+	// a failed rebuild keeps the filter
+	failing := NewGroup("filter-failing", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithKeyFilter(FilterPolicy{Loader: KeyLoaderFunc(func(ctx context.Context, add func(key string)) error {
+		add("Tom")
+		return errors.New("db down")
+	})}))
+	defer failing.Close()
+	if err := failing.RebuildFilter(context.Background()); err == nil {
+		t.Fatalf("expect the loader error")
+	}
+	// never loaded, everything passes
+	if _, err := failing.Get("Sam"); err != nil {
+		t.Fatalf("filter not ready should let keys through, got %v", err)
+	}
+}
+
+// waitRebuilt waits for the next rebuild to load the keys and swap the filter
+func waitRebuilt(g *Group, loaded chan struct{}) {
+	<-loaded
+	g.filter.rebuilding.Lock()
+	g.filter.rebuilding.Unlock()
+}
+
+func TestKeyFilterSnapshot(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	g := NewGroup("filter-snapshot", 2<<10, getter, WithKeyFilter(FilterPolicy{Keys: 100}))
+	defer g.Close()
+	g.AddKeys("Tom", "Jack")
+	var buf bytes.Buffer
+	if err := g.SnapshotFilter(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// the restored filter is ready before its Loader ran, Close cancels the Loader
+	restored := NewGroup("filter-restored", 2<<10, getter, WithKeyFilter(FilterPolicy{
+		Loader: KeyLoaderFunc(func(ctx context.Context, add func(key string)) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}),
+	}))
+	defer restored.Close()
+	if err := restored.RestoreFilter(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.Get("Jack"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.Get("Sam"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Sam is not in the snapshot, got %v", err)
+	}
+
+	if err := NewGroup("filter-none", 2<<10, getter).SnapshotFilter(&buf); err == nil {
+		t.Fatalf("expect an error without a key filter")
+	}
+}
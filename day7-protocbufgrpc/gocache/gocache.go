@@ -1,11 +1,16 @@
 package gocache
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	pb "gocache/gocachepb"
 	"gocache/singleflight"
-	"log"
+	"hash/crc32"
+	"math/rand"
 	"sync"
-	pb "gocache/gocachepb"
+	"sync/atomic"
+	"time"
 )
 
 // gocache is the main process
@@ -24,15 +29,178 @@ func (f GetterFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
 
+// A GetterContext is a Getter that can be cancelled, ctx carries the deadline
+// and cancellation of the caller who started the load (possibly on another peer).
+// NewGroup uses GetContext instead of Get once the getter implements it.
+type GetterContext interface {
+	GetContext(ctx context.Context, key string) ([]byte, error)
+}
+
+// A GetterContextFunc implements GetterContext (and Getter) with a function.
+type GetterContextFunc func(ctx context.Context, key string) ([]byte, error)
+
+// GetContext implements GetterContext interface function
+func (f GetterContextFunc) GetContext(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+// Get implements Getter interface function without deadline
+func (f GetterContextFunc) Get(key string) ([]byte, error) {
+	return f(context.Background(), key)
+}
+
+// A TTLGetter is a GetterContext which also decides how long each value stays
+// in the cache, a ttl > 0 overrides the group TTL and 0 keeps the group TTL.
+type TTLGetter interface {
+	GetWithTTL(ctx context.Context, key string) ([]byte, time.Duration, error)
+}
+
+// A TTLGetterFunc implements TTLGetter (and Getter) with a function.
+type TTLGetterFunc func(ctx context.Context, key string) ([]byte, time.Duration, error)
+
+// GetWithTTL implements TTLGetter interface function
+func (f TTLGetterFunc) GetWithTTL(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	return f(ctx, key)
+}
+
+// Get implements Getter interface function without deadline
+func (f TTLGetterFunc) Get(key string) ([]byte, error) {
+	bytes, _, err := f(context.Background(), key)
+	return bytes, err
+}
+
+// getterTTL adapts a Getter to TTLGetter, values use the group TTL
+// and a plain Getter (not GetterContext) cannot be cancelled
+type getterTTL struct {
+	Getter
+}
+
+func (g getterTTL) GetWithTTL(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	if getter, ok := g.Getter.(GetterContext); ok {
+		bytes, err := getter.GetContext(ctx, key)
+		return bytes, 0, err
+	}
+	bytes, err := g.Get(key)
+	return bytes, 0, err
+}
+
 // A Group is a cache namespace with unique name, e.g. scores, name
 type Group struct {
-	name      string
-	getter    Getter
+	name   string
+	getter TTLGetter
+	// cacheBytes is the budget of mainCache and hotCache together, 0 no limit
+	cacheBytes int64
+	// mainCache holds the values this peer is the owner of
 	mainCache cache
-	picker    PeerPicker
+	// hotCache holds popular values owned by other peers, to save the round trip
+	hotCache cache
+	// hotCacheShare is the part of cacheBytes the hotCache may take before it is evicted first
+	hotCacheShare float64
+	// hotCacheOdds, 1 in hotCacheOdds remote values goes into the hotCache,
+	// so mostly the values asked for again and again end up there
+	hotCacheOdds int
+	picker       PeerPicker
+	// stats are the counters read by Stats
+	stats groupStats
+	// loadLatency is the latency of the loads from peers or the getter
+	loadLatency histogram
 	// use singleflight.Group to make sure that
 	// each key is only fetched once
 	loader *singleflight.Group
+	// peerLoader dedups the gets from peers apart from our own loads, see load
+	peerLoader *singleflight.Group
+	// ttl is the default lifetime of loaded values, 0 never expires
+	ttl time.Duration
+	// janitorInterval is how often expired values are removed in background
+	janitorInterval time.Duration
+	// replicas is the number of peers holding each key, see WithReplicas
+	replicas int
+	// requestPolicy retries and hedges the peer loads within budget when set
+	requestPolicy *RequestPolicy
+	budget        *retryBudget
+	// batchGetter loads the local misses of GetMany at once when the getter is a BatchGetter
+	batchGetter BatchGetter
+	// refreshAhead is how long before expiry a value asked for is reloaded, 0 never
+	refreshAhead time.Duration
+	// refreshing holds the keys reloading in background
+	refreshing sync.Map
+	// negativeTTL is how long ErrNotFound is cached
+	negativeTTL time.Duration
+	// filter rejects the keys which dont exist, see WithKeyFilter
+	filter *keyFilter
+	// closed stops the background goroutines of the group, see Close
+	closed    chan struct{}
+	closeOnce sync.Once
+	// version counts the invalidations of the keys by Set and Remove, versions
+	// holds the version of the last one per slot of keys, see invalidate
+	version  atomic.Uint64
+	versions [versionSlots]atomic.Uint64
+}
+
+const (
+	// default interval of the janitor removing expired values
+	defaultJanitorInterval = time.Minute
+	// default share of the group budget for hot values, same as groupcache
+	defaultHotCacheShare = 1.0 / 8
+	// default odds of a remote value to be kept in the hot cache
+	defaultHotCacheOdds = 10
+)
+
+// WeightUnit is the cache bytes worth one weight on the hash ring, see WeightOf
+const WeightUnit = 64 << 20
+
+// A GroupOption configures a Group in NewGroup
+type GroupOption func(*Group)
+
+// WithTTL sets the default lifetime of the values loaded by the group,
+// TTLGetter can still override it per value
+func WithTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.ttl = ttl
+	}
+}
+
+// WithJanitorInterval sets how often the expired values are removed in background,
+// expired values are always removed lazily on Get in between
+func WithJanitorInterval(interval time.Duration) GroupOption {
+	return func(g *Group) {
+		g.janitorInterval = interval
+	}
+}
+
+// WithHotCache sets the share (0 to 1) of the group byte budget that the hot cache
+// of remote values may take, 0 disables the hot cache
+func WithHotCache(share float64) GroupOption {
+	return func(g *Group) {
+		g.hotCacheShare = share
+	}
+}
+
+// WithEvictionPolicy sets the policy of the main and hot caches, LRU by default,
+// e.g. WithEvictionPolicy(TinyLFU) so a scan of cold keys doesnt flush the popular ones
+func WithEvictionPolicy(policy Policy) GroupOption {
+	return func(g *Group) {
+		g.mainCache.newPolicy = policy
+		g.hotCache.newPolicy = policy
+	}
+}
+
+// WithShards splits the main and hot caches into n shards locked independently,
+// each with 1/n of the budget. By default there is one per MB of budget, up to 16.
+func WithShards(n int) GroupOption {
+	return func(g *Group) {
+		g.mainCache.nshards = n
+		g.hotCache.nshards = n
+	}
+}
+
+// WithReplicas keeps each key on n peers, the owner and its successors on the
+// placement, when the picker is a ReplicaPicker. Loads try them in order so a
+// key stays cached when its owner is down, Set and Remove go to all of them.
+func WithReplicas(n int) GroupOption {
+	return func(g *Group) {
+		g.replicas = n
+	}
 }
 
 // global vars
@@ -52,22 +220,87 @@ func (g *Group) RegisterNodes(picker PeerPicker) {
 }
 
 // NewGroup create a new instance of Group
-func NewGroup(name string, maxBytes int64, getter Getter) *Group {
+// getter may also implement GetterContext or TTLGetter
+func NewGroup(name string, maxBytes int64, getter Getter, opts ...GroupOption) *Group {
 	if getter == nil {
 		panic("nil Getter")
 	}
 	mu.Lock()
 	defer mu.Unlock()
+	ttlGetter, ok := getter.(TTLGetter)
+	if !ok {
+		ttlGetter = getterTTL{getter}
+	}
 	// each group has a cache
 	group := &Group{
-		name:      name,
-		getter:    getter,
-		mainCache: cache{maxBytes: maxBytes},
+		name:            name,
+		getter:          ttlGetter,
+		loader:          &singleflight.Group{},
+		peerLoader:      &singleflight.Group{},
+		cacheBytes:      maxBytes,
+		hotCacheShare:   defaultHotCacheShare,
+		hotCacheOdds:    defaultHotCacheOdds,
+		janitorInterval: defaultJanitorInterval,
+		replicas:        1,
+		negativeTTL:     defaultNegativeTTL,
+		closed:          make(chan struct{}),
+	}
+	group.batchGetter, _ = getter.(BatchGetter)
+	// each cache may take the whole budget, populateCache shares it between them,
+	// admission policies like TinyLFU need to know it to reject entries themselves
+	group.mainCache.maxBytes = maxBytes
+	group.hotCache.maxBytes = maxBytes
+	for _, opt := range opts {
+		opt(group)
+	}
+	// values can only expire with a group TTL or a TTLGetter
+	if group.ttl > 0 || ok {
+		go group.janitor()
+	}
+	if group.filter != nil && group.filter.policy.Loader != nil {
+		go group.rebuilder()
+	}
+	// the group replaced stops its goroutines, its values are still served
+	if old, ok := groups[name]; ok {
+		old.stop()
 	}
 	groups[name] = group
 	return group
 }
 
+// Close stops the background goroutines of the group and unregisters it, the
+// values cached are still served by Get but not cleaned up anymore. A group
+// replaced by NewGroup with the same name is closed too.
+func (g *Group) Close() {
+	g.stop()
+	mu.Lock()
+	defer mu.Unlock()
+	if groups[g.name] == g {
+		delete(groups, g.name)
+	}
+}
+
+// stop stops the background goroutines, mu may be held
+func (g *Group) stop() {
+	g.closeOnce.Do(func() { close(g.closed) })
+}
+
+// janitor removes the expired values periodically, so values nobody asks for
+// again dont hold the cache bytes until the LRU evicts them
+func (g *Group) janitor() {
+	ticker := time.NewTicker(g.janitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			g.mainCache.removeExpired()
+			g.hotCache.removeExpired()
+		case <-g.closed:
+			return
+		}
+	}
+}
+
 // GetGroup returns the named group previously created with NewGroup, or
 // nil if there's no such group.
 func GetGroup(name string) *Group {
@@ -82,33 +315,86 @@ func GetGroup(name string) *Group {
 // MOST IMPORTANT Get value for a key from the group
 // when it called for remote node, the remote node also has to call its Get and cache the value into remote cache
 func (g *Group) Get(key string) (ByteView, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// GetContext is Get with a context, the deadline and cancellation of ctx are passed
+// to the remote peer and to the GetterContext, so a caller who gives up stops the
+// load everywhere in the cluster
+func (g *Group) GetContext(ctx context.Context, key string) (ByteView, error) {
+	g.stats.gets.Add(1)
 	if key == "" {
 		return ByteView{}, fmt.Errorf("key is required")
 	}
 
-	if v, ok := g.mainCache.get(key); ok {
-		log.Println("[GoCache] hit")
+	if v, ok := g.lookupCache(key); ok {
+		g.stats.cacheHits.Add(1)
+		if v.missing {
+			g.stats.negativeHits.Add(1)
+			return ByteView{}, ErrNotFound
+		}
 		return v, nil
 	}
+	if err := ctx.Err(); err != nil {
+		return ByteView{}, err
+	}
+	if !g.mayExist(key) {
+		return ByteView{}, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	g.stats.loads.Add(1)
 	// no hit, retrieve from remote peer OR local source with callback Getter
-	return g.load(key)
+	return g.load(ctx, key)
 }
 
-func (g *Group) load(key string) (value ByteView, err error) {
+func (g *Group) load(ctx context.Context, key string) (value ByteView, err error) {
 	// each key is only fetched once (either locally or remotely)
 	// regardless of the number of concurrent callers.
-	view, err := g.loader.Do(key, func() (interface{}, error) {
-		if g.picker != nil {
-			// we register peers, we see if the node is remote or not.
-			// if remote, we ask remote to send GET request
-			if remote, ok := g.picker.PickPeer(key); ok {
-				if value, err := g.getFromRemote(remote, key); err == nil {
-					return value, nil
-				}
+	// the load runs with the ctx of the first caller, the others stop waiting once their own ctx is done
+	// and load again if the first caller gave up before them
+	// the gets from peers have their own flights: two nodes that disagree on the
+	// owner forward the key to each other, sharing the flight of our own load
+	// would make each node wait for the other
+	loader := g.loader
+	if isPeerRequest(ctx) {
+		loader = g.peerLoader
+	}
+	view, err, _ := loader.DoContext(ctx, key, func(ctx context.Context) (interface{}, error) {
+		ctx = g.loadStarted(ctx)
+		g.stats.loadsDeduped.Add(1)
+		defer func(start time.Time) { g.loadLatency.observe(time.Since(start)) }(time.Now())
+		// a request from a peer is loaded here, the peer already picked this node
+		if isPeerRequest(ctx) {
+			return g.getLocal(ctx, key)
+		}
+		// we see which nodes hold the key, the owner first then its replicas,
+		// and ask them in order until one answers
+		peers, replica := g.pickPeers(key)
+		if g.requestPolicy != nil {
+			return g.loadHedged(ctx, key, peers, replica)
+		}
+		for _, remote := range peers {
+			// this node is next, we get locally
+			if remote == nil {
+				return g.getLocal(ctx, key)
+			}
+			value, err := g.getFromRemote(ctx, remote, key, replica)
+			if err == nil {
+				g.stats.peerLoads.Add(1)
+				return value, nil
+			}
+			// the peer asked the origin already, dont ask it again
+			if errors.Is(err, ErrNotFound) {
+				g.cacheNotFound(ctx, key, g.remoteCache(replica))
+				return nil, err
+			}
+			g.stats.peerErrors.Add(1)
+			// the caller gave up, dont fall back to the local source
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
 			}
 		}
-		// if no picker registered/no remote node/ remote is myself, we get locally
-		return g.getLocal(key)
+		// if all remote nodes failed, we get locally
+		return g.getLocal(ctx, key)
 	})
 	if err == nil {
 		return view.(ByteView), nil
@@ -116,37 +402,289 @@ func (g *Group) load(key string) (value ByteView, err error) {
 	return
 }
 
+// pickPeers returns the peers holding key in the order to ask them, nil for this
+// node, and whether this node is one of them
+func (g *Group) pickPeers(key string) (peers []PeerClient, replica bool) {
+	if g.picker == nil {
+		return []PeerClient{nil}, true
+	}
+	if picker, ok := g.picker.(ReplicaPicker); ok && g.replicas > 1 {
+		peers = picker.PickPeers(key, g.replicas)
+	} else if remote, ok := g.picker.PickPeer(key); ok {
+		peers = []PeerClient{remote}
+	}
+	if len(peers) == 0 {
+		return []PeerClient{nil}, true
+	}
+	for _, peer := range peers {
+		if peer == nil {
+			replica = true
+		}
+	}
+	return peers, replica
+}
+
+// lookupCache looks for key in the owned values first, then in the hot remote ones,
+// a value stale or about to expire is reloaded in background
+func (g *Group) lookupCache(key string) (ByteView, bool) {
+	if v, ok := g.mainCache.get(key); ok {
+		g.revalidate(key, v, false)
+		return v, true
+	}
+	v, ok := g.hotCache.get(key)
+	if ok {
+		g.revalidate(key, v, true)
+	}
+	return v, ok
+}
+
 // FOR DISTRIBUTED CASE
-// the core idea is that we dont cache remote value, otherwise each node will cache same value redundantly
-func (g *Group) getFromRemote(node PeerClient, key string) (ByteView, error) {
+// the core idea is that we dont cache remote value in mainCache, otherwise each node will cache same value redundantly
+// only a few of them go into the small hotCache, the popular keys are asked often so they are likely picked soon
+// unless this node is a replica of key, then it caches the value as its own
+func (g *Group) getFromRemote(ctx context.Context, node PeerClient, key string, replica bool) (ByteView, error) {
 	// bytes, err := node.Request(g.name, key)
 	req := &pb.Request{
 		Group: g.name,
 		Key:   key,
 	}
 	resp := &pb.Response{}
-	err := node.Get(req, resp)
+	err := node.Get(ctx, req, resp)
 	if err != nil {
 		return ByteView{}, err
 	}
+	return g.remoteValue(ctx, key, resp, replica), nil
+}
+
+// remoteCache is the cache of the values from peers, see getFromRemote
+func (g *Group) remoteCache(replica bool) *cache {
+	if replica {
+		return &g.mainCache
+	}
+	return &g.hotCache
+}
+
+// remoteValue is the value of key answered by a peer, cached like getFromRemote says
+func (g *Group) remoteValue(ctx context.Context, key string, resp *pb.Response, replica bool) ByteView {
 	// Capital Value as generated by protoc
-	return ByteView{b: resp.Value}, nil
+	value := ByteView{b: resp.Value}
+	// the hot copy expires together with the owner's value, a stale value the
+	// owner is revalidating is kept a little while so we dont ask again right away
+	if resp.Expire != 0 {
+		value.e = time.Unix(0, resp.Expire)
+		if now := time.Now(); value.e.Before(now) {
+			value.e = now.Add(staleRemoteTTL)
+		}
+	}
+	if replica {
+		g.cacheLoaded(ctx, key, value, &g.mainCache)
+	} else if g.hotCacheShare > 0 && rand.Intn(g.hotCacheOdds) == 0 {
+		g.cacheLoaded(ctx, key, value, &g.hotCache)
+	}
+	return value
 }
 
 // we call the defined Getter Get() to get value from local source and store in cache
-func (g *Group) getLocal(key string) (ByteView, error) {
-	bytes, err := g.getter.Get(key)
+func (g *Group) getLocal(ctx context.Context, key string) (ByteView, error) {
+	bytes, ttl, err := g.getter.GetWithTTL(ctx, key)
 
 	if err != nil {
+		g.stats.localLoadErrs.Add(1)
+		if errors.Is(err, ErrNotFound) {
+			g.cacheNotFound(ctx, key, &g.mainCache)
+		}
 		return ByteView{}, err
 	}
+	g.stats.localLoads.Add(1)
+	g.addKey(key)
 	// copy of bytes
-	value := ByteView{b: cloneBytes(bytes)}
-	g.addCache(key, value)
+	value := ByteView{b: cloneBytes(bytes), e: g.expireAt(ttl)}
+	g.cacheLoaded(ctx, key, value, &g.mainCache)
 	return value, nil
 }
 
-// add the retrieved pair to group cache
-func (g *Group) addCache(key string, value ByteView) {
-	g.mainCache.add(key, value)
+// Set stores value under key, the value goes to the peers holding the key so the
+// following Gets from any node see it. It expires after the group TTL.
+// All the replicas are written even if one fails, the first error is returned.
+func (g *Group) Set(ctx context.Context, key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	g.addKey(key)
+	peers, _ := g.pickPeers(key)
+	var firstErr error
+	for _, remote := range peers {
+		if remote == nil {
+			g.setLocal(key, value)
+			continue
+		}
+		if err := remote.Set(ctx, &pb.SetRequest{Group: g.name, Key: key, Value: value}); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if err := g.dropHot(ctx, key, peers); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// Remove invalidates key on the peers holding it, e.g. after the origin changed,
+// so the next Get loads the fresh value through the Getter.
+func (g *Group) Remove(ctx context.Context, key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	peers, _ := g.pickPeers(key)
+	var firstErr error
+	for _, remote := range peers {
+		if remote == nil {
+			g.removeLocal(key)
+			continue
+		}
+		if err := remote.Delete(ctx, &pb.Request{Group: g.name, Key: key}); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if err := g.dropHot(ctx, key, peers); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// dropHot removes the hot copies of key once the holders got the change, ours
+// and the ones of the other peers if the picker lists them. The peers get a
+// Delete, it only drops what they cache as they dont hold the key.
+func (g *Group) dropHot(ctx context.Context, key string, holders []PeerClient) error {
+	g.invalidate(key)
+	g.hotCache.remove(key)
+	picker, ok := g.picker.(BroadcastPicker)
+	if !ok {
+		return nil
+	}
+	var firstErr error
+	for _, peer := range picker.AllPeers() {
+		if containsPeer(holders, peer) {
+			continue
+		}
+		if err := peer.Delete(ctx, &pb.Request{Group: g.name, Key: key}); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func containsPeer(peers []PeerClient, peer PeerClient) bool {
+	for _, p := range peers {
+		if p == peer {
+			return true
+		}
+	}
+	return false
+}
+
+// setLocal stores value in this node cache, used by the owner of the key
+// a load started before for key doesnt cache its older value, forgetting it
+// makes sure the following Gets dont wait for that load either
+func (g *Group) setLocal(key string, value []byte) {
+	g.invalidate(key)
+	g.loader.Forget(key)
+	g.peerLoader.Forget(key)
+	g.addKey(key)
+	g.populateCache(key, ByteView{b: cloneBytes(value), e: g.expireAt(0)}, &g.mainCache)
+}
+
+// removeLocal removes key from this node cache, used by the owner of the key and
+// by the other peers to drop their hot copy
+func (g *Group) removeLocal(key string) {
+	g.invalidate(key)
+	g.loader.Forget(key)
+	g.peerLoader.Forget(key)
+	g.mainCache.remove(key)
+	g.hotCache.remove(key)
+}
+
+// versionSlots is the number of slots the keys are hashed to for their version,
+// a load is also not cached when another key of its slot changed meanwhile
+const versionSlots = 256
+
+// loadVersionKey marks the ctx of a load with the version at its start
+type loadVersionKey struct{}
+
+// loadStarted marks ctx as a load starting now, the values it brings are only
+// cached if their key was not invalidated since, see cacheLoaded
+func (g *Group) loadStarted(ctx context.Context) context.Context {
+	return context.WithValue(ctx, loadVersionKey{}, g.version.Load())
+}
+
+// invalidate bumps the version of key before Set or Remove change it, so the
+// loads in flight for key dont cache the value they got before the change
+func (g *Group) invalidate(key string) {
+	g.versions[crc32.ChecksumIEEE([]byte(key))%versionSlots].Store(g.version.Add(1))
+}
+
+// cacheLoaded adds the value brought by the load of ctx to c, unless key was
+// invalidated after the load started
+func (g *Group) cacheLoaded(ctx context.Context, key string, value ByteView, c *cache) {
+	if start, ok := ctx.Value(loadVersionKey{}).(uint64); ok &&
+		g.versions[crc32.ChecksumIEEE([]byte(key))%versionSlots].Load() > start {
+		return
+	}
+	g.populateCache(key, value, c)
+}
+
+// expireAt returns when a value loaded now with ttl expires, falling back to the group TTL
+func (g *Group) expireAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		ttl = g.ttl
+	}
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// populateCache adds the retrieved pair to main or hot cache, then evicts until both
+// caches together fit into the group budget. The hot cache is evicted first once it
+// takes more than its share, so remote values dont push out the values we own.
+func (g *Group) populateCache(key string, value ByteView, c *cache) {
+	c.add(key, value)
+	for g.cacheBytes > 0 {
+		mainBytes := g.mainCache.bytes()
+		hotBytes := g.hotCache.bytes()
+		if mainBytes+hotBytes <= g.cacheBytes {
+			return
+		}
+		victim := &g.mainCache
+		if mainBytes == 0 || float64(hotBytes) > g.hotCacheShare*float64(g.cacheBytes) {
+			victim = &g.hotCache
+		}
+		victim.removeOldest()
+	}
+}
+
+// WeightOf is the weight on the hash ring of a node caching maxBytes, one per
+// WeightUnit but at least 1, so a 32GB node owns 8 times the keys of a 4GB one
+func WeightOf(maxBytes int64) int {
+	if weight := maxBytes / WeightUnit; weight > 1 {
+		return int(weight)
+	}
+	return 1
+}
+
+// Weight is the weight of this node for the group, to give to AddPeerWeighted of
+// the pools of the other nodes
+func (g *Group) Weight() int {
+	return WeightOf(g.cacheBytes)
+}
+
+// CacheStats returns stats about the provided cache within the group.
+func (g *Group) CacheStats(which CacheType) CacheStats {
+	switch which {
+	case MainCache:
+		return g.mainCache.stats()
+	case HotCache:
+		return g.hotCache.stats()
+	default:
+		return CacheStats{}
+	}
 }
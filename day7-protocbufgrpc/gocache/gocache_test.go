@@ -1,9 +1,16 @@
 package gocache
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	pb "gocache/gocachepb"
 	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // simulated db
@@ -69,3 +76,510 @@ func TestGet(t *testing.T) {
 		t.Fatalf("the value of unknown should be empty, but %s got", vBytes)
 	}
 }
+
+// a GetterContext is cancelled with the caller ctx
+func TestGetContext(t *testing.T) {
+	cancelled := false
+	slow := NewGroup("slow", 2<<10, GetterContextFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			select {
+			case <-ctx.Done():
+				cancelled = true
+				return nil, ctx.Err()
+			case <-time.After(time.Second):
+				return []byte(key), nil
+			}
+		}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := slow.GetContext(ctx, "Tom"); !errors.Is(err, context.DeadlineExceeded) || !cancelled {
+		t.Fatalf("expect getter cancelled by deadline, got err %v", err)
+	}
+	if _, err := slow.GetContext(ctx, "Tom"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expired ctx should not load, got err %v", err)
+	}
+}
+
+// values expire after the group TTL, a TTLGetter overrides it per key
+func TestTTL(t *testing.T) {
+	loads := make(map[string]int)
+	ttls := map[string]time.Duration{"Tom": 0, "Jack": time.Hour}
+	scores := NewGroup("ttlscores", 2<<10, TTLGetterFunc(
+		func(ctx context.Context, key string) ([]byte, time.Duration, error) {
+			loads[key]++
+			return []byte(db[key]), ttls[key], nil
+		}), WithTTL(20*time.Millisecond))
+	defer scores.Close()
+
+	for k := range ttls {
+		scores.Get(k)
+		scores.Get(k)
+		if loads[k] != 1 {
+			t.Fatalf("%s loaded %d times before expiration", k, loads[k])
+		}
+	}
+	time.Sleep(30 * time.Millisecond)
+	for k := range ttls {
+		scores.Get(k)
+	}
+	if loads["Tom"] != 2 {
+		t.Fatalf("Tom should be reloaded after the group TTL")
+	}
+	if loads["Jack"] != 1 {
+		t.Fatalf("Jack has its own TTL and should not be reloaded")
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	scores := NewGroup("janitorscores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}), WithTTL(10*time.Millisecond), WithJanitorInterval(5*time.Millisecond))
+	defer scores.Close()
+	scores.Get("Tom")
+	time.Sleep(50 * time.Millisecond)
+
+	shard := scores.mainCache.shard("Tom")
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if n, bytes := shard.policy.Len(), shard.policy.Bytes(); n != 0 || bytes != 0 {
+		t.Fatalf("janitor left %d entries of %d bytes", n, bytes)
+	}
+}
+
+// a closed group stops its janitor and leaves the registry
+func TestGroupClose(t *testing.T) {
+	scores := NewGroup("closescores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}), WithTTL(5*time.Millisecond), WithJanitorInterval(5*time.Millisecond))
+	scores.Close()
+	scores.Close()
+	if GetGroup("closescores") != nil {
+		t.Fatalf("closed group is still registered")
+	}
+	scores.Get("Tom")
+	time.Sleep(30 * time.Millisecond)
+	if items := scores.CacheStats(MainCache).Items; items != 1 {
+		t.Fatalf("janitor of the closed group removed the expired value, %d items", items)
+	}
+
+	// the group replaced is closed, not the new one
+	old := NewGroup("closescores", 2<<10, GetterFunc(func(key string) ([]byte, error) { return nil, nil }))
+	replaced := NewGroup("closescores", 2<<10, GetterFunc(func(key string) ([]byte, error) { return nil, nil }))
+	defer replaced.Close()
+	old.Close()
+	if GetGroup("closescores") != replaced {
+		t.Fatalf("closing the replaced group unregistered the new one")
+	}
+	select {
+	case <-old.closed:
+	default:
+		t.Fatalf("replaced group is not closed")
+	}
+}
+
+func TestSetRemove(t *testing.T) {
+	loads := 0
+	scores := NewGroup("setscores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			return []byte(db[key]), nil
+		}))
+	ctx := context.Background()
+
+	if err := scores.Set(ctx, "Tom", []byte("700")); err != nil {
+		t.Fatal(err)
+	}
+	if view, err := scores.Get("Tom"); err != nil || view.String() != "700" || loads != 0 {
+		t.Fatalf("Set value should be served without loading, got %q", view.String())
+	}
+	if err := scores.Remove(ctx, "Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if view, err := scores.Get("Tom"); err != nil || view.String() != "630" || loads != 1 {
+		t.Fatalf("removed key should be loaded again, got %q", view.String())
+	}
+}
+
+// a load in flight during Set or Remove doesnt cache the value it got before
+func TestSetRemoveDuringLoad(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var loads atomic.Int32
+	scores := NewGroup("setduringload", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if loads.Add(1) == 1 {
+				close(started)
+				<-release
+			}
+			return []byte("old"), nil
+		}))
+	ctx := context.Background()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scores.Get("Tom")
+	}()
+	<-started
+	if err := scores.Set(ctx, "Tom", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	<-done
+	if view, err := scores.Get("Tom"); err != nil || view.String() != "new" {
+		t.Fatalf("Set value should not be overwritten by the load, got %q", view.String())
+	}
+
+	started, release, done = make(chan struct{}), make(chan struct{}), make(chan struct{})
+	loads.Store(0)
+	scores.Remove(ctx, "Tom")
+	go func() {
+		defer close(done)
+		scores.Get("Tom")
+	}()
+	<-started
+	scores.Remove(ctx, "Tom")
+	close(release)
+	<-done
+	scores.Get("Tom")
+	if n := loads.Load(); n != 2 {
+		t.Fatalf("removed key should be loaded again, loaded %d times", n)
+	}
+}
+
+// testPeers is a PeerPicker owning the keys starting with "remote",
+// it serves them from db without network
+type testPeers struct {
+	gets int
+}
+
+func (p *testPeers) PickPeer(key string) (PeerClient, bool) {
+	if strings.HasPrefix(key, "remote") {
+		return p, true
+	}
+	return nil, false
+}
+
+func (p *testPeers) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	p.gets++
+	out.Value = []byte(in.Key + "-value")
+	return nil
+}
+
+func (p *testPeers) Set(ctx context.Context, in *pb.SetRequest) error { return nil }
+
+func (p *testPeers) Delete(ctx context.Context, in *pb.Request) error { return nil }
+
+// replicaPeer is a peer of replicaPeers, it fails when down
+type replicaPeer struct {
+	down                bool
+	gets, sets, deletes int
+}
+
+func (p *replicaPeer) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	p.gets++
+	if p.down {
+		return errors.New("peer down")
+	}
+	out.Value = []byte(in.Key + "-replica")
+	return nil
+}
+
+func (p *replicaPeer) Set(ctx context.Context, in *pb.SetRequest) error {
+	p.sets++
+	return nil
+}
+
+func (p *replicaPeer) Delete(ctx context.Context, in *pb.Request) error {
+	p.deletes++
+	return nil
+}
+
+// replicaPeers is a ReplicaPicker placing every key on its peers in order
+type replicaPeers []PeerClient
+
+func (p replicaPeers) PickPeer(key string) (PeerClient, bool) {
+	return p[0], p[0] != nil
+}
+
+func (p replicaPeers) PickPeers(key string, n int) []PeerClient {
+	if n > len(p) {
+		n = len(p)
+	}
+	return p[:n]
+}
+
+func TestReplicas(t *testing.T) {
+	owner, successor := &replicaPeer{down: true}, &replicaPeer{}
+	var localGets int
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		localGets++
+		return []byte(key + "-local"), nil
+	})
+
+	// the owner is down, its successor serves the key
+	g := NewGroup("replicas", 2<<10, getter, WithReplicas(2))
+	g.RegisterNodes(replicaPeers{owner, successor, nil})
+	if view, err := g.Get("Tom"); err != nil || view.String() != "Tom-replica" {
+		t.Fatalf("failed to get Tom from the successor, got %q %v", view.String(), err)
+	}
+	if owner.gets != 1 || successor.gets != 1 || localGets != 0 {
+		t.Fatalf("owner asked %d, successor %d, getter %d times", owner.gets, successor.gets, localGets)
+	}
+	if stats := g.CacheStats(MainCache); stats.Items != 0 {
+		t.Fatalf("value of other replicas should not be in main cache, stats %+v", stats)
+	}
+
+	// writes go to every replica, this node included
+	g = NewGroup("replicas-self", 2<<10, getter, WithReplicas(3))
+	g.RegisterNodes(replicaPeers{owner, successor, nil})
+	g.Set(context.Background(), "Jack", []byte("1"))
+	g.Remove(context.Background(), "Jack")
+	if owner.sets != 1 || successor.sets != 1 || owner.deletes != 1 || successor.deletes != 1 {
+		t.Fatalf("writes not fanned out, owner %+v successor %+v", owner, successor)
+	}
+
+	// a replica keeps the value of the others as its own
+	if view, err := g.Get("Sam"); err != nil || view.String() != "Sam-replica" {
+		t.Fatalf("failed to get Sam, got %q %v", view.String(), err)
+	}
+	if stats := g.CacheStats(MainCache); stats.Items != 1 {
+		t.Fatalf("replica should keep the value in main cache, stats %+v", stats)
+	}
+
+	// all other replicas are down, this node loads it
+	successor.down = true
+	if view, err := g.Get("Tom"); err != nil || view.String() != "Tom-local" || localGets != 1 {
+		t.Fatalf("failed to load Tom locally, got %q %v", view.String(), err)
+	}
+}
+
+func TestHotCache(t *testing.T) {
+	peers := &testPeers{}
+	scores := NewGroup("hotscores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+	scores.RegisterNodes(peers)
+	// keep every remote value
+	scores.hotCacheOdds = 1
+
+	for i := 0; i < 3; i++ {
+		if view, err := scores.Get("remoteTom"); err != nil || view.String() != "remoteTom-value" {
+			t.Fatalf("failed to get remoteTom, got %q", view.String())
+		}
+	}
+	if peers.gets != 1 {
+		t.Fatalf("hot value should be served locally, peer asked %d times", peers.gets)
+	}
+	if stats := scores.CacheStats(HotCache); stats.Hits != 2 || stats.Items != 1 {
+		t.Fatalf("unexpected hot cache stats %+v", stats)
+	}
+	if stats := scores.CacheStats(MainCache); stats.Items != 0 {
+		t.Fatalf("remote value should not be in main cache, stats %+v", stats)
+	}
+
+	// Remove drops the local hot copy
+	scores.Remove(context.Background(), "remoteTom")
+	scores.Get("remoteTom")
+	if peers.gets != 2 {
+		t.Fatalf("removed hot value should be fetched again")
+	}
+}
+
+// groupPeer is a peer node serving group, the groups of the nodes have different
+// names as they share the registry of the test process
+type groupPeer struct {
+	group *Group
+}
+
+func (p groupPeer) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	view, err := p.group.Get(in.Key)
+	out.Value = view.ByteSlice()
+	return err
+}
+
+func (p groupPeer) Set(ctx context.Context, in *pb.SetRequest) error {
+	p.group.setLocal(in.Key, in.Value)
+	return nil
+}
+
+func (p groupPeer) Delete(ctx context.Context, in *pb.Request) error {
+	p.group.removeLocal(in.Key)
+	return nil
+}
+
+// broadcastPeers places every key on owner and lists all the peers
+type broadcastPeers struct {
+	owner PeerClient
+	all   []PeerClient
+}
+
+func (p broadcastPeers) PickPeer(key string) (PeerClient, bool) {
+	return p.owner, true
+}
+
+func (p broadcastPeers) AllPeers() []PeerClient {
+	return p.all
+}
+
+// Set and Remove from any node drop the hot copies the other nodes hold
+func TestHotCacheDroppedOnPeers(t *testing.T) {
+	value := "v1"
+	owner := groupPeer{NewGroup("hotdrop-owner", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(value), nil
+		}))}
+	// only the owner loads
+	remoteOnly := GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s should come from the owner", key)
+	})
+	holder := groupPeer{NewGroup("hotdrop-holder", 2<<10, remoteOnly)}
+	holder.group.RegisterNodes(broadcastPeers{owner: owner, all: []PeerClient{owner}})
+	holder.group.hotCacheOdds = 1
+	writer := NewGroup("hotdrop-writer", 2<<10, remoteOnly)
+	writer.RegisterNodes(broadcastPeers{owner: owner, all: []PeerClient{owner, holder}})
+
+	if view, err := holder.group.Get("Tom"); err != nil || view.String() != "v1" {
+		t.Fatalf("failed to get Tom, got %q %v", view.String(), err)
+	}
+	if stats := holder.group.CacheStats(HotCache); stats.Items != 1 {
+		t.Fatalf("holder should keep a hot copy, stats %+v", stats)
+	}
+
+	if err := writer.Set(context.Background(), "Tom", []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if view, err := holder.group.Get("Tom"); err != nil || view.String() != "v2" {
+		t.Fatalf("holder should see the Set value, got %q %v", view.String(), err)
+	}
+
+	value = "v3"
+	if err := writer.Remove(context.Background(), "Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if view, err := holder.group.Get("Tom"); err != nil || view.String() != "v3" {
+		t.Fatalf("holder should load Tom again after Remove, got %q %v", view.String(), err)
+	}
+}
+
+// loopPeer sends the gets back to its group as a peer request, like two nodes
+// that each think the other owns the key
+type loopPeer struct {
+	group *Group
+}
+
+func (p *loopPeer) PickPeer(key string) (PeerClient, bool) {
+	return p, true
+}
+
+func (p *loopPeer) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	view, err := p.group.GetContext(withPeerRequest(ctx), in.Key)
+	out.Value = view.ByteSlice()
+	return err
+}
+
+func (p *loopPeer) Set(ctx context.Context, in *pb.SetRequest) error { return nil }
+
+func (p *loopPeer) Delete(ctx context.Context, in *pb.Request) error { return nil }
+
+// a get forwarded back to us doesn't wait for the load that forwarded it
+func TestPeerRequestOwnFlight(t *testing.T) {
+	g := NewGroup("peerflight", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	g.RegisterNodes(&loopPeer{group: g})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if view, err := g.GetContext(ctx, "Tom"); err != nil || view.String() != "Tom" {
+		t.Fatalf("failed to get Tom, got %q %v", view.String(), err)
+	}
+}
+
+// the hot cache is evicted first once it takes more than its share of the budget
+func TestHotCacheBalance(t *testing.T) {
+	entry := int64(len("remote00") + len("remote00-value"))
+	scores := NewGroup("hotbalance", 10*entry, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte("local-value-of" + key), nil
+		}), WithHotCache(0.3))
+	scores.RegisterNodes(&testPeers{})
+	scores.hotCacheOdds = 1
+
+	for i := 0; i < 20; i++ {
+		scores.Get(fmt.Sprintf("remote%02d", i))
+		scores.Get(fmt.Sprintf("local%02d", i))
+	}
+	main, hot := scores.CacheStats(MainCache), scores.CacheStats(HotCache)
+	if main.Bytes+hot.Bytes > 10*entry {
+		t.Fatalf("caches use %d bytes over the budget %d", main.Bytes+hot.Bytes, 10*entry)
+	}
+	if hot.Bytes > 3*entry || hot.Items == 0 {
+		t.Fatalf("hot cache uses %d bytes, expect at most its share %d", hot.Bytes, 3*entry)
+	}
+}
+
+func TestStats(t *testing.T) {
+	peers := &testPeers{}
+	scores := NewGroup("statscores", int64(len("Tom630Jack589")), GetterFunc(
+		func(key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s key not exist", key)
+		}), WithHotCache(0))
+	scores.RegisterNodes(peers)
+
+	scores.Get("Tom")
+	scores.Get("Tom")
+	scores.Get("Jack")
+	scores.Get("Sam") // evicts Tom
+	scores.Get("unknown")
+	scores.Get("remoteTom")
+
+	expect := Stats{
+		Gets:          6,
+		CacheHits:     1,
+		Loads:         5,
+		LoadsDeduped:  5,
+		PeerLoads:     1,
+		LocalLoads:    3,
+		LocalLoadErrs: 1,
+		Evictions:     1,
+	}
+	if stats := scores.Stats(); stats != expect {
+		t.Fatalf("stats %+v, expect %+v", stats, expect)
+	}
+	main := scores.CacheStats(MainCache)
+	if main.Items != 2 || main.Bytes != int64(len("Jack589Sam567")) || main.Evictions != 1 {
+		t.Fatalf("unexpected main cache stats %+v", main)
+	}
+}
+
+// concurrent misses of the same key load once
+func TestGetDedup(t *testing.T) {
+	var loads int32
+	scores := NewGroup("dedupscores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			atomic.AddInt32(&loads, 1)
+			time.Sleep(50 * time.Millisecond)
+			return []byte(db[key]), nil
+		}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if view, err := scores.Get("Tom"); err != nil || view.String() != "630" {
+				t.Errorf("failed to get Tom, got %q", view.String())
+			}
+		}()
+	}
+	wg.Wait()
+	if loads != 1 || scores.Stats().LoadsDeduped != 1 {
+		t.Fatalf("Tom loaded %d times, expect 1", loads)
+	}
+}
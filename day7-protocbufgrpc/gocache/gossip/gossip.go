@@ -0,0 +1,522 @@
+// Package gossip discovers the peers of a cluster with a SWIM-style protocol
+// (Das, Gupta & Motivala, https://www.cs.cornell.edu/projects/Quicksilver/public_pdfs/SWIM.pdf).
+//
+// Each node joins through a list of seeds and probes one member per ProbeInterval.
+// A member not answering a ping is probed indirectly through IndirectChecks other
+// members, then suspected and declared dead after SuspicionTimeout unless it refutes.
+// The membership updates are piggybacked on the pings and acks, so they spread
+// through the cluster in O(log n) rounds without extra messages.
+//
+// The live members are fed into the hash ring of a pool with OnChange, e.g.
+//
+//	node, err := gossip.New(gossip.Config{
+//		Name:     ":8001",
+//		BindAddr: "127.0.0.1:7001",
+//		Seeds:    []string{"127.0.0.1:7002"},
+//		OnChange: pool.SetPeers,
+//	})
+package gossip
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultProbeInterval  = time.Second
+	defaultProbeTimeout   = 500 * time.Millisecond
+	defaultIndirectChecks = 3
+	// a suspect member has this many probe intervals to refute
+	defaultSuspicionMult = 5
+	// a dead member is remembered for this many probe intervals
+	defaultDeadMult = 30
+	// an update is piggybacked retransmitMult * log(n+1) times
+	retransmitMult = 4
+	// maxPiggyback bounds the updates sent with a message, besides the sender itself
+	maxPiggyback  = 8
+	maxPacketSize = 65536
+)
+
+// Config configures a Node
+type Config struct {
+	// Name is what the other nodes put on their hash ring, the addr of the pool, e.g. ":8001"
+	Name string
+	// BindAddr is the udp addr to listen on, e.g. "127.0.0.1:7001" or "127.0.0.1:0"
+	BindAddr string
+	// AdvertiseAddr is the udp addr the other nodes send to, the listen addr by default
+	AdvertiseAddr string
+	// Seeds are udp addrs of nodes already in the cluster, empty for the first node
+	Seeds []string
+	// ProbeInterval is how often a member is probed, 1s by default
+	ProbeInterval time.Duration
+	// ProbeTimeout is how long to wait for an ack before probing indirectly, 500ms by default
+	ProbeTimeout time.Duration
+	// IndirectChecks is the number of members asked to probe a member which missed the ack, 3 by default
+	IndirectChecks int
+	// SuspicionTimeout is how long a suspect has to refute before it's dead, 5 probe intervals by default
+	SuspicionTimeout time.Duration
+	// DeadTimeout is how long a dead member is remembered, so the older updates
+	// still going around dont bring it back, before it's removed, 30 probe intervals by default
+	DeadTimeout time.Duration
+	// OnChange is called with the sorted names of the live members, this node
+	// included, every time they change. The weights of the members are not
+	// gossiped: with OnChange set to a pool SetPeers, the members new to the
+	// pool get weight 1 and the others keep the weight they had in the pool.
+	OnChange func(members ...string)
+}
+
+// message is the single udp packet type, Members are the piggybacked updates
+type message struct {
+	Type string `json:"type"`
+	Seq  uint64 `json:"seq,omitempty"`
+	// From is the addr of the sender to reply to
+	From string `json:"from"`
+	// Target is the member to probe for a pingReq
+	Target  string   `json:"target,omitempty"`
+	Members []member `json:"members,omitempty"`
+}
+
+const (
+	msgPing    = "ping"
+	msgAck     = "ack"
+	msgPingReq = "pingReq"
+	// join asks for the whole membership, answered by sync
+	msgJoin = "join"
+	msgSync = "sync"
+)
+
+// Node is a member of the gossip cluster
+type Node struct {
+	config Config
+	conn   *net.UDPConn
+
+	mu         sync.Mutex // guards the fields below
+	self       member
+	members    map[string]*memberState // all known members by addr, self excluded
+	broadcasts []*broadcast
+	seq        uint64
+	acks       map[uint64]func() // called when the ack of seq arrives
+	probeOrder []string
+	probeIdx   int
+
+	notifyMu sync.Mutex // orders the OnChange calls
+	notified []string
+
+	closed chan struct{}
+	wg     sync.WaitGroup
+}
+
+// New starts a node listening on config.BindAddr and joins the cluster of config.Seeds
+func New(config Config) (*Node, error) {
+	if config.ProbeInterval <= 0 {
+		config.ProbeInterval = defaultProbeInterval
+	}
+	if config.ProbeTimeout <= 0 {
+		config.ProbeTimeout = defaultProbeTimeout
+	}
+	if config.IndirectChecks <= 0 {
+		config.IndirectChecks = defaultIndirectChecks
+	}
+	if config.SuspicionTimeout <= 0 {
+		config.SuspicionTimeout = defaultSuspicionMult * config.ProbeInterval
+	}
+	if config.DeadTimeout <= 0 {
+		config.DeadTimeout = defaultDeadMult * config.ProbeInterval
+	}
+	addr, err := net.ResolveUDPAddr("udp", config.BindAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if config.AdvertiseAddr == "" {
+		config.AdvertiseAddr = conn.LocalAddr().String()
+	}
+	if config.Name == "" {
+		config.Name = config.AdvertiseAddr
+	}
+	n := &Node{
+		config:  config,
+		conn:    conn,
+		self:    member{Name: config.Name, Addr: config.AdvertiseAddr},
+		members: make(map[string]*memberState),
+		acks:    make(map[uint64]func()),
+		closed:  make(chan struct{}),
+	}
+	n.notify()
+	n.join()
+	n.wg.Add(2)
+	go n.receive()
+	go n.probeLoop()
+	return n, nil
+}
+
+// Addr is the udp addr other nodes use as seed
+func (n *Node) Addr() string {
+	return n.config.AdvertiseAddr
+}
+
+// Members returns the sorted names of the alive and suspect members, this node included
+func (n *Node) Members() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	names := []string{n.self.Name}
+	for _, m := range n.members {
+		if m.State != stateDead {
+			names = append(names, m.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Leave tells the members this node is leaving, so they remove it right away
+// instead of after the suspicion timeout, then closes the node
+func (n *Node) Leave() error {
+	n.mu.Lock()
+	n.self.Incarnation++
+	n.self.State = stateDead
+	msg := message{Type: msgSync, From: n.self.Addr, Members: []member{n.self}}
+	var addrs []string
+	for addr, m := range n.members {
+		if m.State != stateDead {
+			addrs = append(addrs, addr)
+		}
+	}
+	n.mu.Unlock()
+	for _, addr := range addrs {
+		n.send(addr, msg)
+	}
+	return n.Close()
+}
+
+// Close stops the node without telling the others, they detect it as failed
+func (n *Node) Close() error {
+	select {
+	case <-n.closed:
+		return nil
+	default:
+	}
+	close(n.closed)
+	err := n.conn.Close()
+	n.wg.Wait()
+	n.mu.Lock()
+	for _, m := range n.members {
+		m.stopTimers()
+	}
+	n.mu.Unlock()
+	return err
+}
+
+// join asks the seeds for the membership, it's retried by the probe loop
+// while no member is known
+func (n *Node) join() {
+	for _, seed := range n.config.Seeds {
+		if seed != "" && seed != n.self.Addr {
+			n.send(seed, message{Type: msgJoin, From: n.self.Addr, Members: []member{n.self}})
+		}
+	}
+}
+
+func (n *Node) receive() {
+	defer n.wg.Done()
+	buf := make([]byte, maxPacketSize)
+	for {
+		size, _, err := n.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		var msg message
+		if err := json.Unmarshal(buf[:size], &msg); err != nil {
+			continue
+		}
+		n.handle(msg)
+	}
+}
+
+func (n *Node) handle(msg message) {
+	for _, m := range msg.Members {
+		n.merge(m)
+	}
+	switch msg.Type {
+	case msgPing:
+		n.send(msg.From, n.message(msgAck, msg.Seq))
+	case msgAck:
+		n.mu.Lock()
+		ack := n.acks[msg.Seq]
+		delete(n.acks, msg.Seq)
+		n.mu.Unlock()
+		if ack != nil {
+			ack()
+		}
+	case msgPingReq:
+		// probe the target for the requester and relay its ack
+		from, seq := msg.From, msg.Seq
+		ping := n.expectAck(func() {
+			n.send(from, n.message(msgAck, seq))
+		})
+		time.AfterFunc(n.config.ProbeTimeout, func() { n.forgetAck(ping) })
+		n.send(msg.Target, n.message(msgPing, ping))
+	case msgJoin:
+		n.mu.Lock()
+		all := []member{n.self}
+		for _, m := range n.members {
+			all = append(all, m.member)
+		}
+		n.mu.Unlock()
+		n.send(msg.From, message{Type: msgSync, From: n.self.Addr, Members: all})
+	}
+}
+
+// merge applies an update about a member, it's queued to be gossiped on if it's news
+func (n *Node) merge(m member) {
+	n.mu.Lock()
+	if m.Addr == n.self.Addr {
+		// refute a suspicion about ourselves with a higher incarnation,
+		// self is sent with every message so it's not queued
+		if m.State != stateAlive && m.Incarnation >= n.self.Incarnation && n.self.State == stateAlive {
+			n.self.Incarnation = m.Incarnation + 1
+		}
+		n.mu.Unlock()
+		return
+	}
+	cur, ok := n.members[m.Addr]
+	if ok && !m.overrides(cur.member) {
+		n.mu.Unlock()
+		return
+	}
+	if !ok {
+		cur = &memberState{}
+		n.members[m.Addr] = cur
+	}
+	cur.stopTimers()
+	changed := !ok || cur.State != m.State
+	cur.member = m
+	switch m.State {
+	case stateSuspect:
+		cur.suspectTimer = time.AfterFunc(n.config.SuspicionTimeout, func() {
+			n.merge(member{Name: m.Name, Addr: m.Addr, Incarnation: m.Incarnation, State: stateDead})
+		})
+	case stateDead:
+		cur.deadTimer = time.AfterFunc(n.config.DeadTimeout, func() { n.forget(m) })
+	}
+	n.queue(m)
+	n.mu.Unlock()
+	if changed {
+		log.Printf("[gossip %s] %s (%s) is %s", n.self.Addr, m.Name, m.Addr, m.State)
+		n.notify()
+	}
+}
+
+// forget removes the dead member m unless it came back since, so the members
+// and the join answers dont grow with every node that ever left
+func (n *Node) forget(m member) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if cur, ok := n.members[m.Addr]; ok && cur.member == m {
+		delete(n.members, m.Addr)
+	}
+}
+
+// queue adds the update to the broadcasts, replacing an older one of the same member, n.mu must be held
+func (n *Node) queue(m member) {
+	for i, b := range n.broadcasts {
+		if b.member.Addr == m.Addr {
+			n.broadcasts = append(n.broadcasts[:i], n.broadcasts[i+1:]...)
+			break
+		}
+	}
+	n.broadcasts = append(n.broadcasts, &broadcast{member: m})
+}
+
+// message builds a message carrying this node and the least sent updates
+func (n *Node) message(typ string, seq uint64) message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	limit := retransmitMult * int(math.Ceil(math.Log10(float64(len(n.members)+2))))
+	sort.SliceStable(n.broadcasts, func(i, j int) bool {
+		return n.broadcasts[i].transmits < n.broadcasts[j].transmits
+	})
+	members := []member{n.self}
+	kept := n.broadcasts[:0]
+	for i, b := range n.broadcasts {
+		if i < maxPiggyback {
+			members = append(members, b.member)
+			b.transmits++
+		}
+		if b.transmits < limit {
+			kept = append(kept, b)
+		}
+	}
+	n.broadcasts = kept
+	return message{Type: typ, Seq: seq, From: n.self.Addr, Members: members}
+}
+
+func (n *Node) send(addr string, msg message) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	n.conn.WriteToUDP(data, udpAddr)
+}
+
+// expectAck registers ack to be called when the ack of the returned seq arrives
+func (n *Node) expectAck(ack func()) uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.seq++
+	n.acks[n.seq] = ack
+	return n.seq
+}
+
+func (n *Node) forgetAck(seq uint64) {
+	n.mu.Lock()
+	delete(n.acks, seq)
+	n.mu.Unlock()
+}
+
+func (n *Node) probeLoop() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.config.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.closed:
+			return
+		case <-ticker.C:
+			n.probe()
+		}
+	}
+}
+
+// probe pings the next member in a shuffled round robin, then asks others to
+// ping it when the ack doesn't arrive in time, and suspects it at last
+func (n *Node) probe() {
+	target, ok := n.nextTarget()
+	if !ok {
+		// alone, the seeds may have started after us
+		n.join()
+		return
+	}
+	acked := make(chan struct{})
+	var once sync.Once
+	seq := n.expectAck(func() { once.Do(func() { close(acked) }) })
+	defer n.forgetAck(seq)
+
+	n.send(target.Addr, n.message(msgPing, seq))
+	if n.waitAck(acked, n.config.ProbeTimeout) {
+		return
+	}
+	for _, addr := range n.randomMembers(n.config.IndirectChecks, target.Addr) {
+		msg := n.message(msgPingReq, seq)
+		msg.Target = target.Addr
+		n.send(addr, msg)
+	}
+	// the indirect probes take two round trips
+	if n.waitAck(acked, n.config.ProbeTimeout) {
+		return
+	}
+	n.mu.Lock()
+	cur, ok := n.members[target.Addr]
+	n.mu.Unlock()
+	if ok && cur.State == stateAlive && cur.Incarnation == target.Incarnation {
+		n.merge(member{Name: target.Name, Addr: target.Addr, Incarnation: target.Incarnation, State: stateSuspect})
+	}
+}
+
+func (n *Node) waitAck(acked chan struct{}, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-acked:
+		return true
+	case <-timer.C:
+		return false
+	case <-n.closed:
+		return true
+	}
+}
+
+// nextTarget returns the next live member to probe, the order is shuffled once
+// every member was probed so each one is probed in bounded time
+func (n *Node) nextTarget() (member, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for tries := 0; tries < 2; tries++ {
+		for ; n.probeIdx < len(n.probeOrder); n.probeIdx++ {
+			if m, ok := n.members[n.probeOrder[n.probeIdx]]; ok && m.State != stateDead {
+				n.probeIdx++
+				return m.member, true
+			}
+		}
+		n.probeOrder = n.probeOrder[:0]
+		for addr := range n.members {
+			n.probeOrder = append(n.probeOrder, addr)
+		}
+		rand.Shuffle(len(n.probeOrder), func(i, j int) {
+			n.probeOrder[i], n.probeOrder[j] = n.probeOrder[j], n.probeOrder[i]
+		})
+		n.probeIdx = 0
+	}
+	return member{}, false
+}
+
+// randomMembers returns the addrs of up to k random live members except exclude
+func (n *Node) randomMembers(k int, exclude string) []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var addrs []string
+	for addr, m := range n.members {
+		if addr != exclude && m.State == stateAlive {
+			addrs = append(addrs, addr)
+		}
+	}
+	rand.Shuffle(len(addrs), func(i, j int) { addrs[i], addrs[j] = addrs[j], addrs[i] })
+	if len(addrs) > k {
+		addrs = addrs[:k]
+	}
+	return addrs
+}
+
+// notify calls OnChange if the live members changed since the last call
+func (n *Node) notify() {
+	if n.config.OnChange == nil {
+		return
+	}
+	n.notifyMu.Lock()
+	defer n.notifyMu.Unlock()
+	members := n.Members()
+	if equal(members, n.notified) {
+		return
+	}
+	n.notified = members
+	n.config.OnChange(members...)
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,143 @@
+package gossip
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startNodes starts n nodes on loopback joining through the first one, the names
+// are node0, node1...
+func startNodes(t *testing.T, n int, onChange func(members ...string)) []*Node {
+	t.Helper()
+	nodes := make([]*Node, n)
+	for i := range nodes {
+		config := Config{
+			Name:             fmt.Sprintf("node%d", i),
+			BindAddr:         "127.0.0.1:0",
+			ProbeInterval:    20 * time.Millisecond,
+			ProbeTimeout:     10 * time.Millisecond,
+			SuspicionTimeout: 100 * time.Millisecond,
+			DeadTimeout:      200 * time.Millisecond,
+		}
+		if i > 0 {
+			config.Seeds = []string{nodes[0].Addr()}
+		}
+		if i == 0 {
+			config.OnChange = onChange
+		}
+		node, err := New(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = node
+		t.Cleanup(func() { node.Close() })
+	}
+	return nodes
+}
+
+// waitMembers waits until every node sees expect as members
+func waitMembers(t *testing.T, nodes []*Node, expect []string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for _, node := range nodes {
+		for !reflect.DeepEqual(node.Members(), expect) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s sees %v, expect %v", node.config.Name, node.Members(), expect)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestJoinAndFailure(t *testing.T) {
+	var mu sync.Mutex
+	var changes [][]string
+	nodes := startNodes(t, 4, func(members ...string) {
+		mu.Lock()
+		changes = append(changes, members)
+		mu.Unlock()
+	})
+	waitMembers(t, nodes, []string{"node0", "node1", "node2", "node3"})
+
+	// node3 stops answering, it's suspected then removed after the suspicion timeout
+	nodes[3].Close()
+	waitMembers(t, nodes[:3], []string{"node0", "node1", "node2"})
+	// and forgotten after the dead timeout
+	deadline := time.Now().Add(5 * time.Second)
+	for _, node := range nodes[:3] {
+		for {
+			node.mu.Lock()
+			_, known := node.members[nodes[3].Addr()]
+			node.mu.Unlock()
+			if !known {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s still knows the dead node3", node.config.Name)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if last := changes[len(changes)-1]; !reflect.DeepEqual(last, []string{"node0", "node1", "node2"}) {
+		t.Fatalf("OnChange is not called with the live members, last call %v", last)
+	}
+	if !reflect.DeepEqual(changes[0], []string{"node0"}) {
+		t.Fatalf("OnChange should start with the node itself, got %v", changes[0])
+	}
+}
+
+func TestLeave(t *testing.T) {
+	nodes := startNodes(t, 3, nil)
+	waitMembers(t, nodes, []string{"node0", "node1", "node2"})
+
+	start := time.Now()
+	nodes[2].Leave()
+	waitMembers(t, nodes[:2], []string{"node0", "node1"})
+	if elapsed := time.Since(start); elapsed > nodes[0].config.SuspicionTimeout {
+		t.Fatalf("leaving node should be removed without suspicion, took %v", elapsed)
+	}
+}
+
+// a wrong suspicion is refuted by the suspect with a higher incarnation
+func TestRefute(t *testing.T) {
+	nodes := startNodes(t, 3, nil)
+	waitMembers(t, nodes, []string{"node0", "node1", "node2"})
+
+	nodes[0].merge(member{Name: "node1", Addr: nodes[1].Addr(), State: stateSuspect})
+	time.Sleep(3 * nodes[0].config.SuspicionTimeout)
+	waitMembers(t, nodes, []string{"node0", "node1", "node2"})
+	nodes[1].mu.Lock()
+	defer nodes[1].mu.Unlock()
+	if nodes[1].self.Incarnation == 0 {
+		t.Fatalf("node1 did not refute the suspicion")
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	alive := member{Incarnation: 1, State: stateAlive}
+	suspect := member{Incarnation: 1, State: stateSuspect}
+	dead := member{Incarnation: 1, State: stateDead}
+	refuted := member{Incarnation: 2, State: stateAlive}
+	for _, c := range []struct {
+		update, cur member
+		expect      bool
+	}{
+		{suspect, alive, true},
+		{alive, suspect, false},
+		{refuted, suspect, true},
+		{dead, suspect, true},
+		{suspect, dead, false},
+		{refuted, dead, true},
+		{dead, dead, false},
+	} {
+		if got := c.update.overrides(c.cur); got != c.expect {
+			t.Errorf("%s/%d overrides %s/%d = %v, expect %v", c.update.State, c.update.Incarnation, c.cur.State, c.cur.Incarnation, got, c.expect)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package gossip
+
+import "time"
+
+// state of a member as seen by this node
+type state int
+
+const (
+	stateAlive state = iota
+	// stateSuspect is a member which missed a probe, it's still a member until
+	// the suspicion timeout unless it refutes the suspicion
+	stateSuspect
+	stateDead
+)
+
+func (s state) String() string {
+	switch s {
+	case stateAlive:
+		return "alive"
+	case stateSuspect:
+		return "suspect"
+	default:
+		return "dead"
+	}
+}
+
+// member is the state of a node gossiped around, the incarnation is only
+// increased by the node itself to refute a suspicion about it
+type member struct {
+	Name        string `json:"name"`
+	Addr        string `json:"addr"`
+	Incarnation uint64 `json:"inc"`
+	State       state  `json:"state"`
+}
+
+// overrides reports whether the update m is newer than the current state cur,
+// following the SWIM rules: a higher incarnation wins, at the same incarnation
+// suspect beats alive and dead beats both
+func (m member) overrides(cur member) bool {
+	switch m.State {
+	case stateAlive:
+		return m.Incarnation > cur.Incarnation
+	case stateSuspect:
+		if cur.State == stateAlive {
+			return m.Incarnation >= cur.Incarnation
+		}
+		return cur.State == stateSuspect && m.Incarnation > cur.Incarnation
+	default:
+		return cur.State != stateDead && m.Incarnation >= cur.Incarnation
+	}
+}
+
+// memberState is a member with its local bookkeeping
+type memberState struct {
+	member
+	// suspectTimer declares the member dead once the suspicion times out
+	suspectTimer *time.Timer
+	// deadTimer removes the dead member once the dead timeout is over
+	deadTimer *time.Timer
+}
+
+// stopTimers stops the timers of the previous state of the member
+func (m *memberState) stopTimers() {
+	if m.suspectTimer != nil {
+		m.suspectTimer.Stop()
+		m.suspectTimer = nil
+	}
+	if m.deadTimer != nil {
+		m.deadTimer.Stop()
+		m.deadTimer = nil
+	}
+}
+
+// broadcast is an update piggybacked on the messages until it's sent transmits times
+type broadcast struct {
+	member    member
+	transmits int
+}
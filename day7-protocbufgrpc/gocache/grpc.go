@@ -2,43 +2,65 @@ package gocache
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"gocache/consistenthash"
 	pb "gocache/gocachepb"
 	"log"
 	"net"
 	"sync"
-	"strings"
+	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/reflection"
-	"time"
+	"google.golang.org/grpc/status"
 )
 
 const (
 	defaultPrefix   = "/_gocache/"
 	defaultReplicas = 3
+	// peer requests without a caller deadline use this timeout
+	defaultPeerTimeout = 1 * time.Second
+)
+
+// keepalive settings shared by the peer connections, the client pings an idle
+// connection every keepaliveTime and the server must allow pings that often
+// otherwise it answers with GOAWAY "too_many_pings"
+const (
+	keepaliveTime    = 10 * time.Second
+	keepaliveTimeout = 3 * time.Second
+	keepaliveMinTime = 5 * time.Second
 )
 
 // HTTPPool works as 1. client implements PeerPicker for a pool of HTTP peers.
 // 2. server implements ServeHTTP
 type GrpcPool struct {
 	pb.UnimplementedGroupCacheServer
-	// this peer's base URL, e.g. "https://example.net:8000"
-	base string
+	// the peers and their clients, see peerSet
+	*peerSet[*grpcClient]
 	// prefix for peer communication
-	prefix      string
-	mu          sync.Mutex               // guards peers and httpGetters
-	peerRing    *consistenthash.HashRing // inside the ring is peer names
-	grpcClients map[string]*grpcClient   // each remote node is a httpClient with addr baseURL
-	
+	prefix string
+	mu     sync.Mutex     // guards server, health and admin
+	server *grpc.Server   // set once Serve is called, stopped by Stop
+	health *health.Server // answers the health probes of the peers
+	// admin serves the Admin service next to GroupCache, see SetAdmin
+	admin bool
 }
 
 var _ PeerPicker = (*GrpcPool)(nil)
 
 type grpcClient struct {
+	// the addr is the ip:port to dial
+	*peerState
 	// baseURL is the addr of the remote server
 	baseURL string
+	mu      sync.Mutex       // guards conn and closed
+	conn    *grpc.ClientConn // long-lived connection, dialed on first use
+	closed  bool
 }
 
 // Interface Compliance Check, Go compiler checks at compile time that grpcClient implements all the methods required by the PeerClient interface.
@@ -46,38 +68,11 @@ var _ PeerClient = (*grpcClient)(nil)
 
 // NewGrpcPool initializes an GRPC pool of peers.
 func NewGrpcPool(base string) *GrpcPool {
-	return &GrpcPool{
-		base:   base,
-		prefix: defaultPrefix,
-	}
-}
-
-// Add peer names into the consistenthash ring, wrapped consisenthash Add
-func (p *GrpcPool) Add(peers ...string) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	p.peerRing = consistenthash.New(defaultReplicas, nil)
-	p.peerRing.Add(peers...)
-	p.grpcClients = make(map[string]*grpcClient, len(peers))
-	// each peer act as a client ready to send requests
-	for _, peer := range peers {
-		p.grpcClients[peer] = &grpcClient{baseURL: peer + p.prefix}
-	}
-}
-
-// implements the peerPicker interface methods
-// all peers are stored in ring p.httpClients
-func (p *GrpcPool) PickPeer(key string) (PeerClient, bool) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	// based on the key, we select the node/peer on the ring, consistent hash makes sure certains key belongs to one node
-	if vnode := p.peerRing.Get(key); vnode != "" && vnode != p.base {
-		// vnode is not myself
-		p.Log("Pick peer %s", vnode)
-		return p.grpcClients[vnode], true
-	}
-	// no peer picked, get locally myself
-	return nil, false
+	p := &GrpcPool{prefix: defaultPrefix}
+	p.peerSet = newPeerSet(base, func(state *peerState) *grpcClient {
+		return &grpcClient{peerState: state, baseURL: state.addr + p.prefix}
+	})
+	return p
 }
 
 // Log info with server name
@@ -95,46 +90,273 @@ func (p *GrpcPool) Get(ctx context.Context, in *pb.Request) (*pb.Response, error
 		p.Log("no such group %v", in.Group)
 		return response, fmt.Errorf("no such group %v", in.Group)
 	}
-	value, err := group.Get(in.Key)
+	group.stats.serverRequests.Add(1)
+	p.serving.Add(1)
+	defer p.serving.Add(-1)
+	// ctx carries the deadline of the calling peer and is cancelled once it gives up
+	value, err := group.GetContext(withPeerRequest(ctx), in.Key)
+	if errors.Is(err, ErrNotFound) {
+		return response, status.Error(codes.NotFound, err.Error())
+	}
 	if err != nil {
 		p.Log("get key %v error %v", in.Key, err)
 		return response, err
 	}
 
 	response.Value = value.ByteSlice()
+	response.Load = p.serving.Load()
+	if !value.Expire().IsZero() {
+		response.Expire = value.Expire().UnixNano()
+	}
 	return response, nil
 }
 
+// GetMulti gets the keys a peer asked for at once, the keys that failed to load
+// are missing from the response
+func (p *GrpcPool) GetMulti(ctx context.Context, in *pb.MultiRequest) (*pb.MultiResponse, error) {
+	p.Log("GetMulti %s %d keys", in.Group, len(in.Keys))
+	group := GetGroup(in.Group)
+	if group == nil {
+		p.Log("no such group %v", in.Group)
+		return &pb.MultiResponse{}, fmt.Errorf("no such group %v", in.Group)
+	}
+	group.stats.serverRequests.Add(int64(len(in.Keys)))
+	p.serving.Add(1)
+	defer p.serving.Add(-1)
+	values, err := group.getMany(withPeerRequest(ctx), in.Keys)
+	if err != nil {
+		p.Log("get %d keys error %v", len(in.Keys), err)
+		if ctx.Err() != nil {
+			return &pb.MultiResponse{}, ctx.Err()
+		}
+	}
+	return multiResponse(values), nil
+}
+
+// Set stores the value sent by a peer, this node owns the key so it's not routed again
+func (p *GrpcPool) Set(ctx context.Context, in *pb.SetRequest) (*pb.Response, error) {
+	p.Log("Set %s %s", in.Group, in.Key)
+	group := GetGroup(in.Group)
+	if group == nil {
+		p.Log("no such group %v", in.Group)
+		return &pb.Response{}, fmt.Errorf("no such group %v", in.Group)
+	}
+	group.setLocal(in.Key, in.Value)
+	return &pb.Response{}, nil
+}
+
+// Delete removes the key sent by a peer, this node owns the key so it's not routed again
+func (p *GrpcPool) Delete(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+	p.Log("Delete %s %s", in.Group, in.Key)
+	group := GetGroup(in.Group)
+	if group == nil {
+		p.Log("no such group %v", in.Group)
+		return &pb.Response{}, fmt.Errorf("no such group %v", in.Group)
+	}
+	group.removeLocal(in.Key)
+	return &pb.Response{}, nil
+}
+
+// SetAdmin serves the Admin service on the peer port when enabled, off by default.
+// The Admin service has no auth, anyone reaching the port can change the peers,
+// so only enable it where the peer port is private. It must be called before Serve.
+func (p *GrpcPool) SetAdmin(enabled bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.admin = enabled
+}
+
+// Run listens on p.base and serves the grpc requests until Stop is called
 func (p *GrpcPool) Run() {
 	listen, err := net.Listen("tcp", p.base)
 	if err != nil {
 		panic(err)
 	}
+	if err := p.Serve(listen); err != nil {
+		panic(err)
+	}
+}
 
-	server := grpc.NewServer()
+// Serve serves the grpc requests on an existing listener, it blocks until Stop is called
+func (p *GrpcPool) Serve(listen net.Listener) error {
+	server := grpc.NewServer(grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+		MinTime:             keepaliveMinTime,
+		PermitWithoutStream: true,
+	}))
 	pb.RegisterGroupCacheServer(server, p)
+	p.mu.Lock()
+	if p.admin {
+		pb.RegisterAdminServer(server, &adminServer{pool: p})
+	}
+	p.mu.Unlock()
+	// serving by default, Stop turns it to not serving before draining
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
 	// p.Log("Run listen %+v p.base %s server %+v", listen, p.base, server)
 
 	reflection.Register(server)
-	err = server.Serve(listen)
-	if err != nil {
-		panic(err)
+	p.mu.Lock()
+	p.server = server
+	p.health = healthServer
+	p.mu.Unlock()
+	return server.Serve(listen)
+}
+
+// Stop shuts the pool down, the server (if running) finishes the pending rpcs
+// and all the peer connections are closed.
+func (p *GrpcPool) Stop() {
+	p.mu.Lock()
+	server := p.server
+	healthServer := p.health
+	p.server = nil
+	p.health = nil
+	p.mu.Unlock()
+
+	if healthServer != nil {
+		healthServer.Shutdown()
 	}
+	if server != nil {
+		server.GracefulStop()
+	}
+	p.peerSet.close()
 }
 
 // GRPC CLIENT
+// dial returns the connection to the peer, it's created lazily on the first rpc
+// and reused afterwards. grpc.ClientConn multiplexes concurrent rpcs over one
+// HTTP/2 transport and reconnects by itself, keepalive pings detect dead peers.
+func (g *grpcClient) dial() (*grpc.ClientConn, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return nil, fmt.Errorf("peer %s is closed", g.baseURL)
+	}
+	if g.conn != nil {
+		return g.conn, nil
+	}
+	// the target should not contain BaseURL /_gocache/, only ip:port
+	conn, err := grpc.NewClient(g.addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                keepaliveTime,
+			Timeout:             keepaliveTimeout,
+			PermitWithoutStream: true,
+		}))
+	if err != nil {
+		return nil, err
+	}
+	g.conn = conn
+	return conn, nil
+}
+
 // func name matches .proto service also for CLIENT!
-func (g *grpcClient) Get(in *pb.Request, out *pb.Response) error {
-	// Dial addr should not contain BaseURL /_gocache/, only ip:port
-	portIndex := strings.Index(g.baseURL, "/")
-	c, err := grpc.Dial(g.baseURL[:portIndex], grpc.WithInsecure())
+// grpc sends the ctx deadline to the server and cancels the rpc there once ctx is done
+func (g *grpcClient) Get(ctx context.Context, in *pb.Request, out *pb.Response) (err error) {
+	defer func(start time.Time) { g.metrics.observe("get", start, err) }(time.Now())
+	c, err := g.dial()
 	if err != nil {
 		return err
 	}
 	client := pb.NewGroupCacheClient(c)
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	defer cancel()
-	response, err := client.Get(ctx, in)
+	var response *pb.Response
+	err = g.guard(ctx, func(ctx context.Context) (err error) {
+		g.inflight.Add(1)
+		defer g.inflight.Add(-1)
+		response, err = client.Get(ctx, in)
+		return err
+	})
+	if status.Code(err) == codes.NotFound {
+		return fmt.Errorf("%s: %w", status.Convert(err).Message(), ErrNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	g.report(response.Load)
 	out.Value = response.Value
+	out.Expire = response.Expire
+	out.Load = response.Load
+	return nil
+}
+
+// GetMulti gets many keys from the peer in one rpc
+func (g *grpcClient) GetMulti(ctx context.Context, in *pb.MultiRequest, out *pb.MultiResponse) (err error) {
+	defer func(start time.Time) { g.metrics.observe("getmulti", start, err) }(time.Now())
+	c, err := g.dial()
+	if err != nil {
+		return err
+	}
+	var response *pb.MultiResponse
+	err = g.guard(ctx, func(ctx context.Context) (err error) {
+		g.inflight.Add(1)
+		defer g.inflight.Add(-1)
+		response, err = pb.NewGroupCacheClient(c).GetMulti(ctx, in)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	out.Values, out.NotFound = response.Values, response.NotFound
+	return nil
+}
+
+func (g *grpcClient) Set(ctx context.Context, in *pb.SetRequest) (err error) {
+	defer func(start time.Time) { g.metrics.observe("set", start, err) }(time.Now())
+	c, err := g.dial()
+	if err != nil {
+		return err
+	}
+	return g.guard(ctx, func(ctx context.Context) error {
+		_, err := pb.NewGroupCacheClient(c).Set(ctx, in)
+		return err
+	})
+}
+
+func (g *grpcClient) Delete(ctx context.Context, in *pb.Request) (err error) {
+	defer func(start time.Time) { g.metrics.observe("delete", start, err) }(time.Now())
+	c, err := g.dial()
+	if err != nil {
+		return err
+	}
+	return g.guard(ctx, func(ctx context.Context) error {
+		_, err := pb.NewGroupCacheClient(c).Delete(ctx, in)
+		return err
+	})
+}
+
+// probe sends a health check to the peer and reports the result to the breaker,
+// a peer without the health service is fine as long as it answers
+func (g *grpcClient) probe(ctx context.Context) {
+	c, err := g.dial()
+	if err != nil {
+		return
+	}
+	ctx, cancel := withPeerTimeout(ctx)
+	defer cancel()
+	resp, err := healthpb.NewHealthClient(c).Check(ctx, &healthpb.HealthCheckRequest{})
+	if ctx.Err() == context.Canceled {
+		return
+	}
+	g.breaker.done(status.Code(err) == codes.Unimplemented ||
+		(err == nil && resp.Status == healthpb.HealthCheckResponse_SERVING))
+}
+
+// Close closes the connection to the peer, later rpcs fail instead of redialing
+func (g *grpcClient) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.closed = true
+	if g.conn == nil {
+		return nil
+	}
+	err := g.conn.Close()
+	g.conn = nil
 	return err
 }
+
+// withPeerTimeout bounds a peer request by defaultPeerTimeout unless the caller already set a deadline
+func withPeerTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, defaultPeerTimeout)
+}
@@ -0,0 +1,352 @@
+package gocache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pb "gocache/gocachepb"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// startGrpcPool serves a GrpcPool on a random loopback port
+func startGrpcPool(t *testing.T) *GrpcPool {
+	t.Helper()
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewGrpcPool(listen.Addr().String())
+	go pool.Serve(listen)
+	t.Cleanup(pool.Stop)
+	return pool
+}
+
+// the peer connection is dialed once and reused by all the following rpcs,
+// Add closes the connections of removed peers
+func TestGrpcClientReuseConn(t *testing.T) {
+	NewGroup("grpcscores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, nil
+		}))
+	server := startGrpcPool(t)
+
+	pool := NewGrpcPool("client")
+	pool.Add(server.base)
+	defer pool.Stop()
+
+	peer, ok := pool.PickPeer("Tom")
+	if !ok {
+		t.Fatalf("remote peer %s not picked", server.base)
+	}
+	client := peer.(*grpcClient)
+	for i := 0; i < 3; i++ {
+		resp := &pb.Response{}
+		if err := peer.Get(context.Background(), &pb.Request{Group: "grpcscores", Key: "Tom"}, resp); err != nil || string(resp.Value) != "630" {
+			t.Fatalf("failed to get Tom from peer, value %q err %v", resp.Value, err)
+		}
+	}
+	conn := client.conn
+	if conn == nil {
+		t.Fatalf("connection is not kept")
+	}
+	// the same peer set keeps the connection
+	pool.Add(server.base)
+	if p, _ := pool.PickPeer("Tom"); p.(*grpcClient) != client || client.conn != conn {
+		t.Fatalf("connection is not reused after Add")
+	}
+
+	// the peer is removed, its connection must be closed
+	pool.Add("client")
+	if conn.GetState() != connectivity.Shutdown {
+		t.Fatalf("connection of removed peer is %v, expect Shutdown", conn.GetState())
+	}
+	if err := client.Get(context.Background(), &pb.Request{Group: "grpcscores", Key: "Tom"}, &pb.Response{}); err == nil {
+		t.Fatalf("closed client should not redial")
+	}
+}
+
+// the caller deadline travels to the remote peer and cancels its Getter
+func TestGrpcDeadlinePropagation(t *testing.T) {
+	cancelled := make(chan struct{})
+	NewGroup("grpcslow", 2<<10, GetterContextFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			<-ctx.Done()
+			close(cancelled)
+			return nil, ctx.Err()
+		}))
+	server := startGrpcPool(t)
+
+	pool := NewGrpcPool("client")
+	pool.Add(server.base)
+	defer pool.Stop()
+	peer, _ := pool.PickPeer("Tom")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := peer.Get(ctx, &pb.Request{Group: "grpcslow", Key: "Tom"}, &pb.Response{}); err == nil {
+		t.Fatalf("expect deadline error")
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatalf("remote getter is not cancelled")
+	}
+}
+
+// Set and Remove are routed to the peer owning the key
+func TestGrpcSetRemove(t *testing.T) {
+	loads := 0
+	scores := NewGroup("grpcsetscores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			return []byte(db[key]), nil
+		}))
+	server := startGrpcPool(t)
+	pool := NewGrpcPool("client")
+	pool.Add(server.base)
+	defer pool.Stop()
+	scores.RegisterNodes(pool)
+	ctx := context.Background()
+
+	if err := scores.Set(ctx, "Tom", []byte("700")); err != nil {
+		t.Fatal(err)
+	}
+	// both pools serve the same in-process group, the owner stored the value into it
+	if view, ok := scores.mainCache.get("Tom"); !ok || view.String() != "700" {
+		t.Fatalf("owner did not store Tom, got %q", view.String())
+	}
+	if err := scores.Remove(ctx, "Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := scores.mainCache.get("Tom"); ok {
+		t.Fatalf("owner did not remove Tom")
+	}
+	if loads != 0 {
+		t.Fatalf("Set and Remove should not load")
+	}
+}
+
+// the ring is changed in place, only the clients of the changed peers are touched
+// and the Admin service changes the peers of a live pool once enabled
+func TestGrpcMembership(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewGrpcPool(listen.Addr().String())
+	server.SetAdmin(true)
+	go server.Serve(listen)
+	defer server.Stop()
+	server.AddPeer(server.base, "peer1", "peer2")
+	clients := make(map[string]*grpcClient)
+	for peer, client := range server.clients {
+		clients[peer] = client
+	}
+
+	conn, err := grpc.NewClient(server.base, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	admin := pb.NewAdminClient(conn)
+	ctx := context.Background()
+
+	// not served unless enabled
+	other := startGrpcPool(t)
+	otherConn, err := grpc.NewClient(other.base, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer otherConn.Close()
+	if _, err := pb.NewAdminClient(otherConn).ListPeers(ctx, &pb.Peers{}); status.Code(err) != codes.Unimplemented {
+		t.Fatalf("Admin should not be served by default, got err %v", err)
+	}
+
+	peers, err := admin.RemovePeers(ctx, &pb.Peers{Peers: []string{"peer1"}})
+	if err != nil || !reflect.DeepEqual(peers.Peers, []string{server.base, "peer2"}) {
+		t.Fatalf("RemovePeers got %v err %v", peers.GetPeers(), err)
+	}
+	if !clients["peer1"].closed {
+		t.Fatalf("client of removed peer1 is not closed")
+	}
+	peers, err = admin.SetPeers(ctx, &pb.Peers{Peers: []string{server.base, "peer2", "peer3"}})
+	if err != nil || !reflect.DeepEqual(peers.Peers, []string{server.base, "peer2", "peer3"}) {
+		t.Fatalf("SetPeers got %v err %v", peers.GetPeers(), err)
+	}
+	if server.clients["peer2"] != clients["peer2"] {
+		t.Fatalf("client of peer2 is not kept")
+	}
+	for i := 0; i < 100; i++ {
+		if peer, ok := server.PickPeer(fmt.Sprint(i)); ok && peer.(*grpcClient).addr == "peer1" {
+			t.Fatalf("removed peer1 is picked")
+		}
+	}
+	if peers, err = admin.ListPeers(ctx, &pb.Peers{}); err != nil || len(peers.Peers) != 3 {
+		t.Fatalf("ListPeers got %v err %v", peers.GetPeers(), err)
+	}
+}
+
+// a busy owner spills the key to the next peer, and the peers report their load
+func TestGrpcBoundedLoad(t *testing.T) {
+	NewGroup("grpcbounded", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	server := startGrpcPool(t)
+
+	pool := NewGrpcPool("client")
+	pool.Add("client", server.base, "peer2")
+	defer pool.Stop()
+	pool.SetBoundedLoad(0.25)
+
+	// find a key owned by the server
+	var key string
+	for i := 0; ; i++ {
+		key = fmt.Sprint(i)
+		if peer, ok := pool.PickPeer(key); ok && peer.(*grpcClient).addr == server.base {
+			break
+		}
+	}
+	client := pool.clients[server.base]
+	resp := &pb.Response{}
+	if err := client.Get(context.Background(), &pb.Request{Group: "grpcbounded", Key: key}, resp); err != nil || string(resp.Value) != key {
+		t.Fatalf("failed to get %s, value %q err %v", key, resp.Value, err)
+	}
+	if resp.Load != 1 || client.reportedAt.Load() == 0 {
+		t.Fatalf("server should report its load with the answer, got %d", resp.Load)
+	}
+
+	client.inflight.Add(10)
+	defer client.inflight.Add(-10)
+	if peer, ok := pool.PickPeer(key); ok && peer.(*grpcClient).addr == server.base {
+		t.Fatalf("busy server should not be picked for %s", key)
+	}
+}
+
+// a peer that's down is skipped once its breaker opens, and picked again once
+// the health probes see it back
+func TestGrpcCircuitBreaker(t *testing.T) {
+	NewGroup("grpcbreaker", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	server := startGrpcPool(t)
+	addr := server.base
+
+	pool := NewGrpcPool("client")
+	pool.Add("client", addr)
+	defer pool.Stop()
+	pool.SetCircuitBreaker(2, time.Hour)
+
+	var key string
+	for i := 0; ; i++ {
+		key = fmt.Sprint(i)
+		if _, ok := pool.PickPeer(key); ok {
+			break
+		}
+	}
+	peer, _ := pool.PickPeer(key)
+	if err := peer.Get(context.Background(), &pb.Request{Group: "grpcbreaker", Key: key}, &pb.Response{}); err != nil {
+		t.Fatalf("failed to get %s: %v", key, err)
+	}
+	server.Stop()
+	for i := 0; i < 2; i++ {
+		if err := peer.Get(context.Background(), &pb.Request{Group: "grpcbreaker", Key: key}, &pb.Response{}); err == nil {
+			t.Fatalf("stopped peer should fail")
+		}
+	}
+	if err := peer.Get(context.Background(), &pb.Request{Group: "grpcbreaker", Key: key}, &pb.Response{}); err != ErrPeerUnavailable {
+		t.Fatalf("open breaker should fail fast, got %v", err)
+	}
+	if _, ok := pool.PickPeer(key); ok {
+		t.Fatalf("unhealthy peer should be skipped for %s", key)
+	}
+
+	// the peer is back on the same addr, a probe closes the breaker
+	listen, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("cannot listen on %s again: %v", addr, err)
+	}
+	restarted := NewGrpcPool(addr)
+	go restarted.Serve(listen)
+	defer restarted.Stop()
+	pool.SetHealthCheck(20 * time.Millisecond)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if p, ok := pool.PickPeer(key); ok && p == peer {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("recovered peer is not picked again")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestGrpcGetMulti(t *testing.T) {
+	NewGroup("grpcmulti", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s key not exist", key)
+		}))
+	server := startGrpcPool(t)
+
+	pool := NewGrpcPool("client")
+	pool.Add(server.base)
+	defer pool.Stop()
+	peer, _ := pool.PickPeer("Tom")
+
+	resp := &pb.MultiResponse{}
+	if err := peer.(MultiPeerClient).GetMulti(context.Background(), &pb.MultiRequest{Group: "grpcmulti", Keys: []string{"Tom", "Jack", "unknown"}}, resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Values) != 2 || string(resp.Values["Tom"].Value) != "630" || string(resp.Values["Jack"].Value) != "589" {
+		t.Fatalf("unexpected values %v", resp.Values)
+	}
+}
+
+func TestGrpcNotFound(t *testing.T) {
+	NewGroup("grpcnotfound", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s key not exist: %w", key, ErrNotFound)
+		}))
+	server := startGrpcPool(t)
+
+	pool := NewGrpcPool("client")
+	pool.Add(server.base)
+	defer pool.Stop()
+	peer, _ := pool.PickPeer("unknown")
+	for i := 0; i < 2*defaultBreakerFailures; i++ {
+		if err := peer.Get(context.Background(), &pb.Request{Group: "grpcnotfound", Key: "unknown"}, &pb.Response{}); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expect ErrNotFound, got %v", err)
+		}
+	}
+	if !pool.clients[server.base].breaker.healthy() {
+		t.Fatalf("not found should not open the breaker")
+	}
+
+	resp := &pb.MultiResponse{}
+	if err := peer.(MultiPeerClient).GetMulti(context.Background(), &pb.MultiRequest{Group: "grpcnotfound", Keys: []string{"Tom", "unknown"}}, resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Values) != 1 || !reflect.DeepEqual(resp.NotFound, []string{"unknown"}) {
+		t.Fatalf("unexpected response %v", resp)
+	}
+}
@@ -0,0 +1,331 @@
+package gocache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	pb "gocache/gocachepb"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// the HTTP peers have no grpc-timeout header, the remaining time of the caller
+// deadline is sent in milliseconds with this header instead
+const timeoutHeader = "Gocache-Timeout"
+
+// notFoundHeader marks the 404 of a key not found, see ErrNotFound, from the
+// 404 of a group not found
+const notFoundHeader = "Gocache-Not-Found"
+
+// HTTPPool works as 1. client implements PeerPicker for a pool of HTTP peers.
+// 2. server implements ServeHTTP
+type HTTPPool struct {
+	// the peers and their clients, this peer's base URL is e.g. "https://example.net:8000"
+	*peerSet[*httpClient]
+	// prefix for peer communication
+	prefix string
+}
+
+// NewHTTPPool initializes an HTTP pool of peers.
+func NewHTTPPool(base string) *HTTPPool {
+	p := &HTTPPool{prefix: defaultPrefix}
+	p.peerSet = newPeerSet(base, func(state *peerState) *httpClient {
+		return &httpClient{peerState: state, baseURL: state.addr + p.prefix}
+	})
+	return p
+}
+
+var _ PeerPicker = (*HTTPPool)(nil)
+
+// Log info with server name
+func (p *HTTPPool) Log(format string, v ...interface{}) {
+	log.Printf("[Server %s] %s", p.base, fmt.Sprintf(format, v...))
+}
+
+// ServeHTTP handle all http requests
+func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, p.prefix) {
+		panic("HTTPPool serving unexpected path: " + r.URL.Path)
+	}
+	p.Log("%s %s", r.Method, r.URL.Path)
+	// GET /<basepath>/ is the health probe of the peers
+	if r.URL.Path == p.prefix && r.Method == http.MethodGet {
+		w.Write([]byte("ok"))
+		return
+	}
+	// /<basepath>/<groupname>/<key> required
+	parts := strings.SplitN(r.URL.Path[len(p.prefix):], "/", 2)
+	if len(parts) != 2 {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	groupName := parts[0]
+	key := parts[1]
+
+	group := GetGroup(groupName)
+	if group == nil {
+		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
+		return
+	}
+
+	// PUT stores the body as value and DELETE removes the key, this node owns
+	// the key so they are not routed again
+	switch r.Method {
+	case http.MethodPut:
+		value, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		group.setLocal(key, value)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodDelete:
+		group.removeLocal(key)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodPost:
+		// POST /<basepath>/<groupname>/ gets the keys of the MultiRequest body
+		p.serveGetMulti(w, r, group)
+		return
+	case http.MethodGet:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	group.stats.serverRequests.Add(1)
+	p.serving.Add(1)
+	defer p.serving.Add(-1)
+	// r.Context() is cancelled once the calling peer goes away, its deadline comes with timeoutHeader
+	ctx := withPeerRequest(r.Context())
+	if ms, err := strconv.ParseInt(r.Header.Get(timeoutHeader), 10, 64); err == nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(ms)*time.Millisecond)
+		defer cancel()
+	}
+	view, err := group.GetContext(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		w.Header().Set(notFoundHeader, "1")
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Write the value to the response body as a proto message.
+	response := &pb.Response{Value: view.ByteSlice(), Load: p.serving.Load()}
+	if !view.Expire().IsZero() {
+		response.Expire = view.Expire().UnixNano()
+	}
+	body, err := proto.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
+}
+
+// serveGetMulti answers a GetMulti request, the keys that failed to load are
+// missing from the response
+func (p *HTTPPool) serveGetMulti(w http.ResponseWriter, r *http.Request, group *Group) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	in := &pb.MultiRequest{}
+	if err := proto.Unmarshal(body, in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	group.stats.serverRequests.Add(int64(len(in.Keys)))
+	ctx := withPeerRequest(r.Context())
+	if ms, err := strconv.ParseInt(r.Header.Get(timeoutHeader), 10, 64); err == nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(ms)*time.Millisecond)
+		defer cancel()
+	}
+	values, err := group.getMany(ctx, in.Keys)
+	if err != nil && ctx.Err() != nil {
+		http.Error(w, ctx.Err().Error(), http.StatusInternalServerError)
+		return
+	}
+	body, err = proto.Marshal(multiResponse(values))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
+}
+
+// httpClient implements the peerClient interface, it's peer as a client role
+type httpClient struct {
+	*peerState
+	// baseURL is the addr of the remote server
+	baseURL string
+}
+
+// the httpClient peer send GET request to remote with addr link
+func (h *httpClient) Get(ctx context.Context, in *pb.Request, out *pb.Response) (err error) {
+	defer func(start time.Time) { h.metrics.observe("get", start, err) }(time.Now())
+	return h.guard(ctx, func(ctx context.Context) error {
+		h.inflight.Add(1)
+		defer h.inflight.Add(-1)
+		// send http GET
+		res, err := h.do(ctx, http.MethodGet, in.GetGroup(), in.GetKey(), nil)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode == http.StatusNotFound && res.Header.Get(notFoundHeader) != "" {
+			return fmt.Errorf("%s: %w", in.GetKey(), ErrNotFound)
+		}
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("server returned: %v", res.Status)
+		}
+
+		bytes, err := io.ReadAll(res.Body)
+		if err != nil {
+			return fmt.Errorf("reading response body: %v", err)
+		}
+		// write bytes to out response
+		if err = proto.Unmarshal(bytes, out); err != nil {
+			return fmt.Errorf("decoding response body: %v", err)
+		}
+		h.report(out.Load)
+		return nil
+	})
+}
+
+// GetMulti sends POST request with the keys as body
+func (h *httpClient) GetMulti(ctx context.Context, in *pb.MultiRequest, out *pb.MultiResponse) (err error) {
+	defer func(start time.Time) { h.metrics.observe("getmulti", start, err) }(time.Now())
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return h.guard(ctx, func(ctx context.Context) error {
+		res, err := h.do(ctx, http.MethodPost, in.GetGroup(), "", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("server returned: %v", res.Status)
+		}
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			return fmt.Errorf("reading response body: %v", err)
+		}
+		if err = proto.Unmarshal(body, out); err != nil {
+			return fmt.Errorf("decoding response body: %v", err)
+		}
+		return nil
+	})
+}
+
+// Set sends PUT request with the value as body
+func (h *httpClient) Set(ctx context.Context, in *pb.SetRequest) (err error) {
+	defer func(start time.Time) { h.metrics.observe("set", start, err) }(time.Now())
+	return h.guard(ctx, func(ctx context.Context) error {
+		res, err := h.do(ctx, http.MethodPut, in.GetGroup(), in.GetKey(), bytes.NewReader(in.GetValue()))
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusNoContent {
+			return fmt.Errorf("server returned: %v", res.Status)
+		}
+		return nil
+	})
+}
+
+// Delete sends DELETE request
+func (h *httpClient) Delete(ctx context.Context, in *pb.Request) (err error) {
+	defer func(start time.Time) { h.metrics.observe("delete", start, err) }(time.Now())
+	return h.guard(ctx, func(ctx context.Context) error {
+		res, err := h.do(ctx, http.MethodDelete, in.GetGroup(), in.GetKey(), nil)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusNoContent {
+			return fmt.Errorf("server returned: %v", res.Status)
+		}
+		return nil
+	})
+}
+
+// probe sends a GET to the prefix of the peer and reports the result to the
+// breaker, any answer but a 5xx means the peer is up
+func (h *httpClient) probe(ctx context.Context) {
+	ctx, cancel := withPeerTimeout(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.baseURL, nil)
+	if err != nil {
+		return
+	}
+	res, err := http.DefaultClient.Do(req)
+	if ctx.Err() == context.Canceled {
+		return
+	}
+	if err == nil {
+		res.Body.Close()
+	}
+	h.breaker.done(err == nil && res.StatusCode < http.StatusInternalServerError)
+}
+
+// Close does nothing, the requests share the connections of http.DefaultClient
+func (h *httpClient) Close() error {
+	return nil
+}
+
+// transportError is a request which got no answer from the peer, the peer is
+// down or too slow, see peerFailed
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string { return e.err.Error() }
+
+func (e *transportError) Unwrap() error { return e.err }
+
+// do sends the request for /<basepath>/<groupname>/<key> with the ctx deadline in timeoutHeader
+func (h *httpClient) do(ctx context.Context, method, group, key string, body io.Reader) (*http.Response, error) {
+	link := fmt.Sprintf(
+		"%v%v/%v",
+		h.baseURL,
+		url.PathEscape(group),
+		url.PathEscape(key),
+	)
+	req, err := http.NewRequestWithContext(ctx, method, link, body)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set(timeoutHeader, strconv.FormatInt(time.Until(deadline).Milliseconds(), 10))
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, &transportError{err}
+	}
+	return res, nil
+}
+
+// Interface Compliance Check, Go compiler checks at compile time that httpClient implements all the methods required by the PeerClient interface.
+var _ PeerClient = (*httpClient)(nil)
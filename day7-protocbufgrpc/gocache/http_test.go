@@ -0,0 +1,293 @@
+package gocache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gocache/consistenthash"
+	pb "gocache/gocachepb"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHTTPPoolGet(t *testing.T) {
+	cancelled := make(chan struct{})
+	NewGroup("httpscores", 2<<10, GetterContextFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			// unknown keys block until the calling peer gives up
+			<-ctx.Done()
+			close(cancelled)
+			return nil, ctx.Err()
+		}))
+	server := httptest.NewServer(NewHTTPPool("server"))
+	defer server.Close()
+
+	pool := NewHTTPPool("client")
+	pool.Add(server.URL)
+	peer, ok := pool.PickPeer("Tom")
+	if !ok {
+		t.Fatalf("remote peer %s not picked", server.URL)
+	}
+	resp := &pb.Response{}
+	if err := peer.Get(context.Background(), &pb.Request{Group: "httpscores", Key: "Tom"}, resp); err != nil || string(resp.Value) != "630" {
+		t.Fatalf("failed to get Tom from peer, value %q err %v", resp.Value, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := peer.Get(ctx, &pb.Request{Group: "httpscores", Key: "unknown"}, &pb.Response{}); err == nil {
+		t.Fatalf("expect deadline error")
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatalf("remote getter is not cancelled")
+	}
+}
+
+// the key goes in the URL path, the peer must load the very same key
+func TestHTTPPoolEscapedKey(t *testing.T) {
+	NewGroup("httpescaped", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	server := httptest.NewServer(NewHTTPPool("server"))
+	defer server.Close()
+
+	pool := NewHTTPPool("client")
+	pool.Add(server.URL)
+	peer, _ := pool.PickPeer("Tom")
+	for _, key := range []string{"Tom Hanks", "a+b", "50%", "a/b?c"} {
+		resp := &pb.Response{}
+		if err := peer.Get(context.Background(), &pb.Request{Group: "httpescaped", Key: key}, resp); err != nil || string(resp.Value) != key {
+			t.Fatalf("failed to get %q from peer, value %q err %v", key, resp.Value, err)
+		}
+	}
+}
+
+func TestHTTPPoolSetRemove(t *testing.T) {
+	scores := NewGroup("httpsetscores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+	server := httptest.NewServer(NewHTTPPool("server"))
+	defer server.Close()
+	pool := NewHTTPPool("client")
+	pool.Add(server.URL)
+	scores.RegisterNodes(pool)
+	ctx := context.Background()
+
+	if err := scores.Set(ctx, "Tom", []byte("700")); err != nil {
+		t.Fatal(err)
+	}
+	if view, ok := scores.mainCache.get("Tom"); !ok || view.String() != "700" {
+		t.Fatalf("owner did not store Tom, got %q", view.String())
+	}
+	if err := scores.Remove(ctx, "Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := scores.mainCache.get("Tom"); ok {
+		t.Fatalf("owner did not remove Tom")
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	pool := NewHTTPPool("http://self")
+	pool.Add("http://self", "http://peer1")
+	admin := httptest.NewServer(AdminHandler(pool))
+	defer admin.Close()
+
+	for _, step := range []struct {
+		method, query string
+		expect        []string
+	}{
+		{http.MethodGet, "", []string{"http://peer1", "http://self"}},
+		{http.MethodPost, "?peer=http://peer2&peer=http://peer3", []string{"http://peer1", "http://peer2", "http://peer3", "http://self"}},
+		{http.MethodDelete, "?peer=http://peer1", []string{"http://peer2", "http://peer3", "http://self"}},
+		{http.MethodPut, "?peer=http://self&peer=http://peer4", []string{"http://peer4", "http://self"}},
+	} {
+		req, _ := http.NewRequest(step.method, admin.URL+step.query, nil)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var peers []string
+		err = json.NewDecoder(res.Body).Decode(&peers)
+		res.Body.Close()
+		if err != nil || !reflect.DeepEqual(peers, step.expect) {
+			t.Fatalf("%s %s got %v err %v, expect %v", step.method, step.query, peers, err, step.expect)
+		}
+	}
+	if peer, ok := pool.PickPeer("Tom"); ok && peer.(*httpClient).addr != "http://peer4" {
+		t.Fatalf("picked removed peer %s", peer.(*httpClient).addr)
+	}
+}
+
+func TestPoolWeights(t *testing.T) {
+	if WeightOf(2<<10) != 1 || WeightOf(4<<30) != 64 {
+		t.Fatalf("WeightOf 2KB = %d and 4GB = %d, expect 1 and 64", WeightOf(2<<10), WeightOf(4<<30))
+	}
+	pool := NewHTTPPool("http://self")
+	pool.SetPeersWeighted(map[string]int{"http://self": 1, "http://big": 4})
+	// SetPeers keeps the weights of the existing peers
+	pool.SetPeers("http://self", "http://big", "http://new")
+	if w := pool.placement.Weight("http://big"); w != 4 {
+		t.Fatalf("weight of big is %d after SetPeers, expect 4", w)
+	}
+	pool.AddPeerWeighted("http://new", 2)
+	if w := pool.placement.Weight("http://new"); w != 2 {
+		t.Fatalf("weight of new is %d, expect 2", w)
+	}
+	// the peers move into another placement with their weights
+	pool.SetPlacement(consistenthash.NewRendezvous())
+	if w := pool.placement.Weight("http://big"); w != 4 {
+		t.Fatalf("weight of big is %d after SetPlacement, expect 4", w)
+	}
+	if peer, ok := pool.PickPeer("Tom"); ok && pool.placement.Get("Tom") != peer.(*httpClient).addr {
+		t.Fatalf("PickPeer does not use the placement")
+	}
+}
+
+func TestHTTPPoolGetMulti(t *testing.T) {
+	NewGroup("httpmulti", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, errors.New("not found")
+		}))
+	server := httptest.NewServer(NewHTTPPool("server"))
+	defer server.Close()
+
+	pool := NewHTTPPool("client")
+	pool.Add(server.URL)
+	peer, _ := pool.PickPeer("Tom")
+	resp := &pb.MultiResponse{}
+	if err := peer.(MultiPeerClient).GetMulti(context.Background(), &pb.MultiRequest{Group: "httpmulti", Keys: []string{"Tom", "Sam", "unknown"}}, resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Values) != 2 || string(resp.Values["Tom"].Value) != "630" || string(resp.Values["Sam"].Value) != "567" {
+		t.Fatalf("unexpected values %v", resp.Values)
+	}
+}
+
+func TestHTTPPoolNotFound(t *testing.T) {
+	NewGroup("httpnotfound", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return nil, ErrNotFound
+		}))
+	server := httptest.NewServer(NewHTTPPool("server"))
+	defer server.Close()
+
+	pool := NewHTTPPool("client")
+	pool.Add(server.URL)
+	peer, _ := pool.PickPeer("Tom")
+	if err := peer.Get(context.Background(), &pb.Request{Group: "httpnotfound", Key: "Tom"}, &pb.Response{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	// a missing group is an error, not a missing key
+	if err := peer.Get(context.Background(), &pb.Request{Group: "nosuchgroup", Key: "Tom"}, &pb.Response{}); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect a plain error for a missing group, got %v", err)
+	}
+}
+
+func TestHTTPPoolCircuitBreaker(t *testing.T) {
+	NewGroup("httpbreaker", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return nil, errors.New("db down")
+		}))
+	server := httptest.NewServer(NewHTTPPool("server"))
+
+	pool := NewHTTPPool("client")
+	pool.Add(server.URL)
+	pool.SetCircuitBreaker(2, time.Hour)
+	peer, _ := pool.PickPeer("Tom")
+	// the errors of the getter are answers, they dont open the breaker
+	for i := 0; i < 3; i++ {
+		if err := peer.Get(context.Background(), &pb.Request{Group: "httpbreaker", Key: "Tom"}, &pb.Response{}); err == nil || err == ErrPeerUnavailable {
+			t.Fatalf("expect the getter error, got %v", err)
+		}
+	}
+	server.Close()
+	for i := 0; i < 2; i++ {
+		if err := peer.Get(context.Background(), &pb.Request{Group: "httpbreaker", Key: "Tom"}, &pb.Response{}); err == nil {
+			t.Fatalf("closed peer should fail")
+		}
+	}
+	if err := peer.Get(context.Background(), &pb.Request{Group: "httpbreaker", Key: "Tom"}, &pb.Response{}); err != ErrPeerUnavailable {
+		t.Fatalf("open breaker should fail fast, got %v", err)
+	}
+	if _, ok := pool.PickPeer("Tom"); ok {
+		t.Fatalf("unhealthy peer should be skipped")
+	}
+}
+
+func TestHTTPPoolHealthCheck(t *testing.T) {
+	server := httptest.NewServer(NewHTTPPool("server"))
+	pool := NewHTTPPool("client")
+	pool.Add(server.URL)
+	pool.SetHealthCheck(10 * time.Millisecond)
+	defer pool.SetHealthCheck(0)
+
+	// the probes of a live peer keep it picked
+	time.Sleep(50 * time.Millisecond)
+	if _, ok := pool.PickPeer("Tom"); !ok {
+		t.Fatalf("live peer should be picked")
+	}
+	// the probes find the dead peer without a request
+	server.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := pool.PickPeer("Tom"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("dead peer is still picked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// a busy owner spills the key to the next peer, and the peers report their load
+func TestHTTPPoolBoundedLoad(t *testing.T) {
+	NewGroup("httpbounded", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	server := httptest.NewServer(NewHTTPPool("server"))
+	defer server.Close()
+
+	pool := NewHTTPPool("client")
+	pool.Add("client", server.URL, "peer2")
+	pool.SetBoundedLoad(0.25)
+
+	// find a key owned by the server
+	var key string
+	for i := 0; ; i++ {
+		key = fmt.Sprint(i)
+		if peer, ok := pool.PickPeer(key); ok && peer.(*httpClient).addr == server.URL {
+			break
+		}
+	}
+	client := pool.clients[server.URL]
+	resp := &pb.Response{}
+	if err := client.Get(context.Background(), &pb.Request{Group: "httpbounded", Key: key}, resp); err != nil || string(resp.Value) != key {
+		t.Fatalf("failed to get %s, value %q err %v", key, resp.Value, err)
+	}
+	if resp.Load != 1 || client.reportedAt.Load() == 0 {
+		t.Fatalf("server should report its load with the answer, got %d", resp.Load)
+	}
+
+	client.inflight.Add(10)
+	defer client.inflight.Add(-10)
+	if peer, ok := pool.PickPeer(key); ok && peer.(*httpClient).addr == server.URL {
+		t.Fatalf("busy server should not be picked for %s", key)
+	}
+}
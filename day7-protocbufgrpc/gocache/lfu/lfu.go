@@ -0,0 +1,183 @@
+package lfu
+
+import (
+	"container/list"
+	"gocache/lru"
+	"time"
+)
+
+// Cache is a LFU cache, it evicts the least frequently used entry and the least
+// recently used one among entries of the same frequency. Get, Add and eviction
+// are O(1) with one DLL per frequency. It is not safe for concurrent access.
+type Cache struct {
+	maxBytes  int64
+	usedBytes int64
+	evictions int64
+	// frequency to DLL of entries with the frequency, front is most recently used
+	freqs map[int]*list.List
+	// minFreq is the lowest frequency in freqs, it may be stale after Remove
+	minFreq int
+	// key string, val is pointer to element/nodes in the DLL of its frequency
+	cache map[string]*list.Element
+	// optional and executed when an entry is purged.
+	OnEvicted func(key string, value lru.Value)
+}
+
+// entry is data type of DLL node
+type entry struct {
+	key    string
+	value  lru.Value
+	expire time.Time // zero never expires
+	freq   int
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+// New is the Constructor of Cache, maxBytes 0 or less means no limit
+func New(maxBytes int64, onEvicted func(string, lru.Value)) *Cache {
+	return &Cache{
+		maxBytes:  maxBytes,
+		freqs:     make(map[int]*list.List),
+		cache:     make(map[string]*list.Element),
+		OnEvicted: onEvicted,
+	}
+}
+
+// Get look ups a key's value and counts the access
+// an expired entry is removed lazily here and reported as a miss
+func (c *Cache) Get(key string) (value lru.Value, ok bool) {
+	if e, ok := c.cache[key]; ok {
+		kvPair := e.Value.(*entry)
+		if kvPair.expired(time.Now()) {
+			c.removeElement(e)
+			return nil, false
+		}
+		c.increment(e)
+		return kvPair.value, true
+	}
+	return nil, false
+}
+
+// Add adds a value to the cache.
+func (c *Cache) Add(key string, value lru.Value) {
+	c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithExpire adds a value to the cache which is valid until expire,
+// zero expire means the value never expires. Updating a value counts as an access.
+func (c *Cache) AddWithExpire(key string, value lru.Value, expire time.Time) {
+	if e, ok := c.cache[key]; ok {
+		kvPair := e.Value.(*entry)
+		c.usedBytes += int64(value.Len()) - int64(kvPair.value.Len())
+		kvPair.value = value
+		kvPair.expire = expire
+		c.increment(e)
+	} else {
+		kvPair := &entry{key: key, value: value, expire: expire, freq: 1}
+		c.cache[key] = c.list(1).PushFront(kvPair)
+		c.minFreq = 1
+		c.usedBytes += int64(len(key)) + int64(value.Len())
+	}
+	// align with lru, if c.maxBytes <= 0 means no limit
+	for c.maxBytes > 0 && c.usedBytes > c.maxBytes && len(c.cache) > 0 {
+		c.RemoveOldest()
+	}
+}
+
+// Remove removes the key from the cache, OnEvicted is called if it was there
+func (c *Cache) Remove(key string) {
+	if e, ok := c.cache[key]; ok {
+		c.removeElement(e)
+	}
+}
+
+// RemoveOldest removes the least frequently used item, named after lru.Cache
+func (c *Cache) RemoveOldest() {
+	if len(c.cache) == 0 {
+		return
+	}
+	l, ok := c.freqs[c.minFreq]
+	if !ok {
+		// minFreq was removed by Remove or expiration, look for the new minimum
+		c.minFreq = 0
+		for freq := range c.freqs {
+			if c.minFreq == 0 || freq < c.minFreq {
+				c.minFreq = freq
+			}
+		}
+		l = c.freqs[c.minFreq]
+	}
+	c.removeElement(l.Back())
+	c.evictions++
+}
+
+// RemoveExpired removes all the expired entries and returns how many were removed
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	removed := 0
+	for _, e := range c.cache {
+		if e.Value.(*entry).expired(now) {
+			c.removeElement(e)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Len the number of cache entries
+func (c *Cache) Len() int {
+	return len(c.cache)
+}
+
+// Bytes the number of bytes used by keys and values
+func (c *Cache) Bytes() int64 {
+	return c.usedBytes
+}
+
+// Evictions the number of entries evicted as the least frequently used
+func (c *Cache) Evictions() int64 {
+	return c.evictions
+}
+
+// list returns the DLL of freq, creating it if needed
+func (c *Cache) list(freq int) *list.List {
+	l, ok := c.freqs[freq]
+	if !ok {
+		l = list.New()
+		c.freqs[freq] = l
+	}
+	return l
+}
+
+// increment moves the element to the front of the next frequency
+func (c *Cache) increment(e *list.Element) {
+	kvPair := e.Value.(*entry)
+	c.unlink(e)
+	if c.minFreq == kvPair.freq && c.freqs[kvPair.freq] == nil {
+		c.minFreq++
+	}
+	kvPair.freq++
+	c.cache[kvPair.key] = c.list(kvPair.freq).PushFront(kvPair)
+}
+
+// unlink removes the element from its frequency DLL, dropping the DLL once empty
+func (c *Cache) unlink(e *list.Element) {
+	freq := e.Value.(*entry).freq
+	l := c.freqs[freq]
+	l.Remove(e)
+	if l.Len() == 0 {
+		delete(c.freqs, freq)
+	}
+}
+
+func (c *Cache) removeElement(e *list.Element) {
+	c.unlink(e)
+	kvPair := e.Value.(*entry)
+	delete(c.cache, kvPair.key)
+	c.usedBytes -= int64(len(kvPair.key)) + int64(kvPair.value.Len())
+	if c.OnEvicted != nil {
+		c.OnEvicted(kvPair.key, kvPair.value)
+	}
+}
@@ -0,0 +1,89 @@
+package lfu
+
+import (
+	"gocache/lru"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (d String) Len() int {
+	return len(d)
+}
+
+func TestGet(t *testing.T) {
+	lfu := New(int64(0), nil)
+	lfu.Add("key1", String("1234"))
+	if v, ok := lfu.Get("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("cache hit key1=1234 failed")
+	}
+	if _, ok := lfu.Get("key2"); ok {
+		t.Fatalf("cache miss key2 failed")
+	}
+}
+
+func TestNegativeSize(t *testing.T) {
+	lfu := New(int64(-1), nil)
+	lfu.Add("key1", String("1234"))
+	lfu.Add("key2", String("5678"))
+	if lfu.Len() != 2 {
+		t.Fatalf("negative maxBytes should not evict, %d left", lfu.Len())
+	}
+}
+
+func TestRemoveOldest(t *testing.T) {
+	keys := make([]string, 0)
+	// room for 3 entries of 4 bytes
+	lfu := New(int64(12), func(key string, value lru.Value) {
+		keys = append(keys, key)
+	})
+	lfu.Add("k1", String("v1"))
+	lfu.Add("k2", String("v2"))
+	lfu.Add("k3", String("v3"))
+	// k1 and k3 are used more than k2
+	lfu.Get("k1")
+	lfu.Get("k1")
+	lfu.Get("k3")
+	lfu.Add("k4", String("v4"))
+	lfu.Add("k5", String("v5"))
+
+	// k2 has the lowest frequency, then k4 is the least recently used of frequency 1
+	if expect := []string{"k2", "k4"}; !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("Call OnEvicted failed, expect keys equals to %+v, got %v", expect, keys)
+	}
+	if lfu.Len() != 3 || lfu.Bytes() != 12 || lfu.Evictions() != 2 {
+		t.Fatalf("expect 3 entries of 12 bytes and 2 evictions, got %d %d %d", lfu.Len(), lfu.Bytes(), lfu.Evictions())
+	}
+}
+
+func TestRemove(t *testing.T) {
+	lfu := New(int64(8), nil)
+	lfu.Add("k1", String("v1"))
+	lfu.Get("k1")
+	lfu.Add("k2", String("v2"))
+	// k1 was the only entry of frequency 2, minFreq is stale until RemoveOldest
+	lfu.Remove("k1")
+	lfu.Add("k3", String("v3"))
+	lfu.Add("k4", String("v4"))
+	if _, ok := lfu.Get("k1"); ok || lfu.Len() != 2 || lfu.Bytes() != 8 {
+		t.Fatalf("Remove k1 failed, %d entries of %d bytes", lfu.Len(), lfu.Bytes())
+	}
+	if _, ok := lfu.Get("k2"); ok {
+		t.Fatalf("k2 should be evicted")
+	}
+}
+
+func TestExpire(t *testing.T) {
+	lfu := New(int64(0), nil)
+	lfu.AddWithExpire("k1", String("v1"), time.Now().Add(-time.Second))
+	lfu.AddWithExpire("k2", String("v2"), time.Now().Add(-time.Second))
+	lfu.AddWithExpire("k3", String("v3"), time.Now().Add(time.Hour))
+	if _, ok := lfu.Get("k1"); ok {
+		t.Fatalf("k1 should expire")
+	}
+	if n := lfu.RemoveExpired(); n != 1 || lfu.Len() != 1 {
+		t.Fatalf("RemoveExpired should remove k2, removed %d", n)
+	}
+}
@@ -1,24 +1,42 @@
 package lru
 
-import "container/list"
+import (
+	"container/list"
+	"time"
+)
 
 // Cache is a LRU cache linked hashmap. It is not safe for concurrent access.
 // we regulate front is most recently used, end is least recently used
-type Cache struct {
+// the size of the entries is counted by a size func, so maxBytes may be bytes,
+// a number of entries or anything else, see NewWithSize
+type Cache[K comparable, V any] struct {
 	maxBytes  int64
 	usedBytes int64
+	// number of entries removed by RemoveOldest to free space
+	evictions int64
+	// size returns the cost of an entry against maxBytes
+	size func(key K, value V) int64
 	// doubly linked list
 	dLL *list.List
-	// key string, val is pointer to element/nodes in DLL
-	cache map[string]*list.Element
+	// key, val is pointer to element/nodes in DLL
+	cache map[K]*list.Element
 	// optional and executed when an entry is purged.
-	OnEvicted func(key string, value Value)
+	OnEvicted func(key K, value V)
 }
 
 // entry is data type of DLL node
-type Entry struct {
-	key   string
-	value Value
+type entry[K comparable, V any] struct {
+	key   K
+	value V
+	// expire is when the entry becomes invalid, zero time never expires
+	expire time.Time
+	// size is the cost of the entry when it was added
+	size int64
+}
+
+// expired reports whether the entry is no longer valid at now
+func (e *entry[K, V]) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
 }
 
 // Value is quite generice, use Len to count how many bytes it takes
@@ -26,66 +44,196 @@ type Value interface {
 	Len() int
 }
 
-// New is the Constructor of Cache
-func New(maxBytes int64, onEvicted func(string, Value)) *Cache {
-	return &Cache{
+// New is the Constructor of a Cache of Values by string keys, an entry takes the
+// bytes of its key and value
+func New(maxBytes int64, onEvicted func(string, Value)) *Cache[string, Value] {
+	return NewWithSize(maxBytes, func(key string, value Value) int64 {
+		return int64(len(key)) + int64(value.Len())
+	}, onEvicted)
+}
+
+// NewWithSize creates a Cache holding entries up to a total size of maxBytes,
+// 0 or less is no limit. A nil size counts each entry as 1, so maxBytes is the number of entries.
+func NewWithSize[K comparable, V any](maxBytes int64, size func(key K, value V) int64, onEvicted func(K, V)) *Cache[K, V] {
+	if size == nil {
+		size = func(K, V) int64 { return 1 }
+	}
+	return &Cache[K, V]{
 		maxBytes:  maxBytes,
+		size:      size,
 		dLL:       list.New(),
-		cache:     make(map[string]*list.Element),
+		cache:     make(map[K]*list.Element),
 		OnEvicted: onEvicted,
 	}
 }
 
 // Get look ups a key's value
-func (c *Cache) Get(key string) (value Value, ok bool) {
+// an expired entry is removed lazily here and reported as a miss
+func (c *Cache[K, V]) Get(key K) (value V, ok bool) {
 	if e, ok := c.cache[key]; ok {
-		c.dLL.MoveToFront(e)
 		// before get e.val we need to cast/make sure type is entry
 		// e.Value is list.list.Element.Value .(*entry) is type assertation
-		kvPair := e.Value.(*Entry)
+		kvPair := e.Value.(*entry[K, V])
+		if kvPair.expired(time.Now()) {
+			c.removeElement(e)
+			return value, false
+		}
+		c.dLL.MoveToFront(e)
 		return kvPair.value, true
 	}
-	return nil, false
+	return value, false
+}
+
+// Peek is Get without making key the most recently used, an expired value is
+// a miss but it stays until Get or RemoveExpired
+func (c *Cache[K, V]) Peek(key K) (value V, ok bool) {
+	if e, ok := c.cache[key]; ok {
+		if kvPair := e.Value.(*entry[K, V]); !kvPair.expired(time.Now()) {
+			return kvPair.value, true
+		}
+	}
+	return value, false
+}
+
+// Contains reports whether key has a value which is not expired, like Peek
+func (c *Cache[K, V]) Contains(key K) bool {
+	_, ok := c.Peek(key)
+	return ok
 }
 
 // Add adds a value to the cache.
-func (c *Cache) Add(key string, value Value) {
+func (c *Cache[K, V]) Add(key K, value V) {
+	c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithExpire adds a value to the cache which is valid until expire,
+// zero expire means the value never expires.
+func (c *Cache[K, V]) AddWithExpire(key K, value V, expire time.Time) {
 	// check if element exists, if so, update and movetofront, else pushfront
 	// map should be updated, and usedByte
+	size := c.size(key, value)
 	if e, ok := c.cache[key]; ok {
 		c.dLL.MoveToFront(e)
 		// update value of e
-		kvPair := e.Value.(*Entry)
-		c.usedBytes += int64(value.Len()) - int64(kvPair.value.Len())
+		kvPair := e.Value.(*entry[K, V])
+		c.usedBytes += size - kvPair.size
 		kvPair.value = value
+		kvPair.expire = expire
+		kvPair.size = size
 	} else {
-		e := c.dLL.PushFront(&Entry{key, value})
+		e := c.dLL.PushFront(&entry[K, V]{key: key, value: value, expire: expire, size: size})
 		c.cache[key] = e
-		c.usedBytes += int64(len(key)) + int64(value.Len())
+		c.usedBytes += size
 	}
+	c.evict()
+}
+
+// evict removes the LRU items until the cache fits into maxBytes, it returns how many
+func (c *Cache[K, V]) evict() int {
 	// if exceed maxByte, we remoe LRU
-	// align with groupcache, if c.maxBytes == 0 means no limit
-	for c.maxBytes != 0 && c.usedBytes > c.maxBytes {
-		c.removeLRU()
+	// align with groupcache, if c.maxBytes <= 0 means no limit
+	n := 0
+	for c.maxBytes > 0 && c.usedBytes > c.maxBytes && c.dLL.Len() > 0 {
+		c.RemoveOldest()
+		n++
+	}
+	return n
+}
+
+// Remove removes the key from the cache, OnEvicted is called if it was there
+func (c *Cache[K, V]) Remove(key K) {
+	if e, ok := c.cache[key]; ok {
+		c.removeElement(e)
 	}
 }
 
 // RemoveOldest removes the LRU item
-func (c *Cache) removeLRU() {
+func (c *Cache[K, V]) RemoveOldest() {
 	// we need to remove from DLL, delete from map, reduce usedBytes with key+val length
 	// execute onEvicted if needed
 	if e := c.dLL.Back(); e != nil {
-		c.dLL.Remove(e)
-		kvPair := e.Value.(*Entry)
-		delete(c.cache, kvPair.key)
-		c.usedBytes -= int64(len(kvPair.key)) + int64(kvPair.value.Len())
-		if c.OnEvicted != nil {
-			c.OnEvicted(kvPair.key, kvPair.value)
+		c.removeElement(e)
+		c.evictions++
+	}
+}
+
+// RemoveExpired removes all the expired entries and returns how many were removed,
+// it walks the whole list so it's meant for a periodic janitor rather than every call
+func (c *Cache[K, V]) RemoveExpired() int {
+	now := time.Now()
+	removed := 0
+	for e := c.dLL.Back(); e != nil; {
+		prev := e.Prev()
+		if e.Value.(*entry[K, V]).expired(now) {
+			c.removeElement(e)
+			removed++
+		}
+		e = prev
+	}
+	return removed
+}
+
+// Resize changes maxBytes and evicts the LRU items which dont fit anymore,
+// it returns how many were evicted. 0 or less is no limit.
+func (c *Cache[K, V]) Resize(maxBytes int64) int {
+	c.maxBytes = maxBytes
+	return c.evict()
+}
+
+// Purge removes all the entries, OnEvicted is called for each of them
+func (c *Cache[K, V]) Purge() {
+	for e := c.dLL.Back(); e != nil; e = c.dLL.Back() {
+		c.removeElement(e)
+	}
+}
+
+// Keys returns the keys of the values not expired, from the most to the least recently used
+func (c *Cache[K, V]) Keys() []K {
+	keys := make([]K, 0, c.dLL.Len())
+	c.Range(func(key K, _ V) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// Range calls f for the values not expired from the most to the least recently
+// used until f returns false, without changing their order. f must not change the cache.
+func (c *Cache[K, V]) Range(f func(key K, value V) bool) {
+	now := time.Now()
+	for e := c.dLL.Front(); e != nil; e = e.Next() {
+		kvPair := e.Value.(*entry[K, V])
+		if kvPair.expired(now) {
+			continue
+		}
+		if !f(kvPair.key, kvPair.value) {
+			return
 		}
 	}
 }
 
+func (c *Cache[K, V]) removeElement(e *list.Element) {
+	c.dLL.Remove(e)
+	kvPair := e.Value.(*entry[K, V])
+	delete(c.cache, kvPair.key)
+	c.usedBytes -= kvPair.size
+	if c.OnEvicted != nil {
+		c.OnEvicted(kvPair.key, kvPair.value)
+	}
+}
+
 // Len the number of cache entries
-func (c *Cache) Len() int {
+func (c *Cache[K, V]) Len() int {
 	return c.dLL.Len()
 }
+
+// Bytes the total size of the entries, the bytes used by keys and values with New
+func (c *Cache[K, V]) Bytes() int64 {
+	return c.usedBytes
+}
+
+// Evictions the number of entries evicted as the least recently used,
+// removed and expired entries are not counted
+func (c *Cache[K, V]) Evictions() int64 {
+	return c.evictions
+}
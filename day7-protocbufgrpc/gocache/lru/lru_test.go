@@ -3,6 +3,7 @@ package lru
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 // As Entry.value it must implement Len() method, so we need to define String type
@@ -24,6 +25,24 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestRemove(t *testing.T) {
+	keys := make([]string, 0)
+	lru := New(int64(0), func(key string, value Value) {
+		keys = append(keys, key)
+	})
+	lru.Add("key1", String("1234"))
+	lru.Add("key2", String("5678"))
+	lru.Remove("key1")
+	lru.Remove("unknown")
+
+	if _, ok := lru.Get("key1"); ok || lru.Len() != 1 || lru.Bytes() != int64(len("key2"+"5678")) {
+		t.Fatalf("Remove key1 failed")
+	}
+	if !reflect.DeepEqual(keys, []string{"key1"}) {
+		t.Fatalf("Remove should call OnEvicted, got %v", keys)
+	}
+}
+
 func TestRemoveoldest(t *testing.T) {
 	k1, k2, k3 := "key1", "key2", "k3"
 	v1, v2, v3 := "value1", "value2", "v3"
@@ -58,4 +77,116 @@ func TestOnEvicted(t *testing.T) {
 	if !reflect.DeepEqual(expect, keys) {
 		t.Fatalf("Call OnEvicted failed, expect keys equals to %+v", expect)
 	}
+	if lru.Evictions() != 2 {
+		t.Fatalf("Evictions %d, expect 2", lru.Evictions())
+	}
+}
+
+func TestExpire(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.AddWithExpire("key1", String("1234"), time.Now().Add(-time.Second))
+	lru.AddWithExpire("key2", String("5678"), time.Now().Add(time.Hour))
+	lru.AddWithExpire("k3", String("v3"), time.Now().Add(-time.Second))
+	lru.Add("k4", String("v4"))
+
+	// expired key1 is removed lazily by Get
+	if _, ok := lru.Get("key1"); ok || lru.Len() != 3 {
+		t.Fatalf("expired key1 should be removed by Get")
+	}
+	if removed := lru.RemoveExpired(); removed != 1 || lru.Len() != 2 {
+		t.Fatalf("RemoveExpired removed %d, expect 1", removed)
+	}
+	if _, ok := lru.Get("key2"); !ok {
+		t.Fatalf("key2 is not expired yet")
+	}
+	if expect := int64(len("key2" + "5678" + "k4" + "v4")); lru.Bytes() != expect {
+		t.Fatalf("usedBytes %d, expect %d", lru.Bytes(), expect)
+	}
+
+	// re-adding a key resets its expiration
+	lru.AddWithExpire("key2", String("56"), time.Now().Add(-time.Second))
+	lru.Add("key2", String("5678"))
+	if _, ok := lru.Get("key2"); !ok {
+		t.Fatalf("key2 expiration should be reset by Add")
+	}
+}
+
+func TestGeneric(t *testing.T) {
+	type point struct{ x, y int }
+	// nil size counts entries, at most 2
+	c := NewWithSize[int, point](2, nil, nil)
+	c.Add(1, point{1, 1})
+	c.Add(2, point{2, 2})
+	c.Add(3, point{3, 3})
+	if _, ok := c.Get(1); ok || c.Len() != 2 || c.Bytes() != 2 {
+		t.Fatalf("expect 1 evicted, len %d", c.Len())
+	}
+	if p, ok := c.Get(3); !ok || p != (point{3, 3}) {
+		t.Fatalf("failed to get 3, got %v", p)
+	}
+
+	// custom size, the value of key is key bytes
+	sized := NewWithSize(10, func(key string, value []byte) int64 { return int64(len(value)) }, nil)
+	sized.Add("a", make([]byte, 6))
+	sized.Add("b", make([]byte, 6))
+	if sized.Contains("a") || !sized.Contains("b") || sized.Bytes() != 6 {
+		t.Fatalf("a should be evicted by size, bytes %d", sized.Bytes())
+	}
+}
+
+func TestPeekKeysRange(t *testing.T) {
+	c := NewWithSize[string, int](0, nil, nil)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Add("c", 3)
+	c.AddWithExpire("old", 0, time.Now().Add(-time.Second))
+
+	// Peek doesnt make a the most recently used
+	if v, ok := c.Peek("a"); !ok || v != 1 {
+		t.Fatalf("failed to peek a")
+	}
+	if !reflect.DeepEqual(c.Keys(), []string{"c", "b", "a"}) {
+		t.Fatalf("unexpected keys %v", c.Keys())
+	}
+	if c.Contains("old") || c.Len() != 4 {
+		t.Fatalf("expired value should be a miss but stay until removed")
+	}
+	c.Get("a")
+	var visited []string
+	c.Range(func(key string, value int) bool {
+		visited = append(visited, key)
+		return key != "c"
+	})
+	if !reflect.DeepEqual(visited, []string{"a", "c"}) {
+		t.Fatalf("Range should stop at c, visited %v", visited)
+	}
+}
+
+func TestResizePurge(t *testing.T) {
+	var evicted []string
+	c := NewWithSize(0, nil, func(key string, value int) {
+		evicted = append(evicted, key)
+	})
+	for i, key := range []string{"a", "b", "c", "d"} {
+		c.Add(key, i)
+	}
+	if n := c.Resize(2); n != 2 || !reflect.DeepEqual(evicted, []string{"a", "b"}) || c.Evictions() != 2 {
+		t.Fatalf("Resize evicted %d %v", n, evicted)
+	}
+	c.Purge()
+	if c.Len() != 0 || c.Bytes() != 0 || len(evicted) != 4 {
+		t.Fatalf("Purge left %d entries, evicted %v", c.Len(), evicted)
+	}
+
+	// a negative size is no limit too
+	c.Add("e", 5)
+	if n := c.Resize(-1); n != 0 || c.Len() != 1 {
+		t.Fatalf("Resize(-1) evicted %d, %d left", n, c.Len())
+	}
+	c = NewWithSize[string, int](-1, nil, nil)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	if c.Len() != 2 {
+		t.Fatalf("negative maxBytes should not evict, %d left", c.Len())
+	}
 }
@@ -0,0 +1,256 @@
+package gocache
+
+// metrics exports the group and peer statistics in the Prometheus text format,
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+// it has no dependency so the handler can be scraped or curl-ed on any node
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds in seconds of the latency histograms
+var latencyBuckets = [...]float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// histogram counts durations into latencyBuckets, the zero value is ready to use
+// and observe never locks
+type histogram struct {
+	counts [len(latencyBuckets) + 1]atomic.Int64 // one per bucket, the last one is +Inf
+	count  atomic.Int64
+	sum    atomic.Int64 // nanoseconds
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := sort.SearchFloat64s(latencyBuckets[:], d.Seconds())
+	h.counts[i].Add(1)
+	h.count.Add(1)
+	h.sum.Add(int64(d))
+}
+
+// write writes the cumulative buckets, sum and count of the histogram named name
+func (h *histogram) write(w io.Writer, name, labels string) {
+	var cumulative int64
+	for i, bound := range latencyBuckets {
+		cumulative += h.counts[i].Load()
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, bound, cumulative)
+	}
+	cumulative += h.counts[len(latencyBuckets)].Load()
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, cumulative)
+	fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, time.Duration(h.sum.Load()).Seconds())
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count.Load())
+}
+
+// add adds the counts of other to h, used to sum histograms for the scrape
+func (h *histogram) add(other *histogram) {
+	for i := range h.counts {
+		h.counts[i].Add(other.counts[i].Load())
+	}
+	h.count.Add(other.count.Load())
+	h.sum.Add(other.sum.Load())
+}
+
+// peerRPC identifies the rpcs of one method sent to one peer
+type peerRPC struct {
+	peer   string
+	method string
+}
+
+type rpcMetrics struct {
+	latency histogram
+	errors  atomic.Int64
+}
+
+// peerMetrics are the metrics of the rpcs a pool sends to one peer, each client
+// has its own so a pool removing a peer doesnt touch the metrics of another pool
+type peerMetrics struct {
+	peer    string
+	methods sync.Map // method to *rpcMetrics
+}
+
+// livePeerMetrics holds the *peerMetrics of the peers in the pools, the scrape
+// only exports them so the series go away with the peers
+var livePeerMetrics sync.Map
+
+// newPeerMetrics creates the metrics of a peer added to a pool and registers them
+func newPeerMetrics(peer string) *peerMetrics {
+	m := &peerMetrics{peer: peer}
+	livePeerMetrics.Store(m, struct{}{})
+	return m
+}
+
+// forget unregisters the metrics of a peer removed from its pool, the rpcs still
+// in flight are recorded but not exported anymore
+func (m *peerMetrics) forget() {
+	livePeerMetrics.Delete(m)
+}
+
+// observe records the latency and the error of a rpc of method started at start
+func (m *peerMetrics) observe(method string, start time.Time, err error) {
+	v, ok := m.methods.Load(method)
+	if !ok {
+		v, _ = m.methods.LoadOrStore(method, &rpcMetrics{})
+	}
+	metrics := v.(*rpcMetrics)
+	metrics.latency.observe(time.Since(start))
+	if err != nil {
+		metrics.errors.Add(1)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// label formats name="value" escaped for the text format
+func label(name, value string) string {
+	return name + `="` + labelEscaper.Replace(value) + `"`
+}
+
+// MetricsHandler returns the handler writing the metrics of all groups and peers
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(w)
+	})
+}
+
+// ServeMetrics serves MetricsHandler on addr/metrics, e.g. the admin port ":9100"
+func ServeMetrics(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", MetricsHandler())
+	return http.ListenAndServe(addr, mux)
+}
+
+func writeMetrics(w io.Writer) {
+	mu.RLock()
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	mu.RUnlock()
+	sort.Strings(names)
+
+	type groupSnapshot struct {
+		labels string
+		group  *Group
+		stats  Stats
+		caches map[string]CacheStats
+	}
+	snapshots := make([]groupSnapshot, 0, len(names))
+	for _, name := range names {
+		group := GetGroup(name)
+		if group == nil {
+			continue
+		}
+		snapshots = append(snapshots, groupSnapshot{
+			labels: label("group", name),
+			group:  group,
+			stats:  group.Stats(),
+			caches: map[string]CacheStats{
+				"main": group.CacheStats(MainCache),
+				"hot":  group.CacheStats(HotCache),
+			},
+		})
+	}
+
+	counters := []struct {
+		name, help string
+		value      func(Stats) int64
+	}{
+		{"gocache_gets_total", "Get requests, including from peers.", func(s Stats) int64 { return s.Gets }},
+		{"gocache_cache_hits_total", "Gets served by the main or hot cache.", func(s Stats) int64 { return s.CacheHits }},
+		{"gocache_loads_total", "Gets that missed the cache.", func(s Stats) int64 { return s.Loads }},
+		{"gocache_loads_deduped_total", "Loads which ran after singleflight.", func(s Stats) int64 { return s.LoadsDeduped }},
+		{"gocache_peer_loads_total", "Values loaded from peers.", func(s Stats) int64 { return s.PeerLoads }},
+		{"gocache_peer_errors_total", "Failed loads from peers.", func(s Stats) int64 { return s.PeerErrors }},
+		{"gocache_peer_retries_total", "Failed loads retried on the next node.", func(s Stats) int64 { return s.PeerRetries }},
+		{"gocache_peer_hedges_total", "Slow loads hedged on the next node.", func(s Stats) int64 { return s.PeerHedges }},
+		{"gocache_local_loads_total", "Values loaded by the Getter.", func(s Stats) int64 { return s.LocalLoads }},
+		{"gocache_local_load_errors_total", "Failed loads of the Getter.", func(s Stats) int64 { return s.LocalLoadErrs }},
+		{"gocache_server_requests_total", "Gets received from peers.", func(s Stats) int64 { return s.ServerRequests }},
+		{"gocache_stale_hits_total", "Expired values served while they reload.", func(s Stats) int64 { return s.StaleHits }},
+		{"gocache_revalidations_total", "Background reloads of stale values.", func(s Stats) int64 { return s.Revalidations }},
+		{"gocache_refresh_aheads_total", "Background reloads of values about to expire.", func(s Stats) int64 { return s.RefreshAheads }},
+		{"gocache_refresh_errors_total", "Failed background reloads.", func(s Stats) int64 { return s.RefreshErrors }},
+		{"gocache_negative_hits_total", "Cache hits of keys not found.", func(s Stats) int64 { return s.NegativeHits }},
+		{"gocache_filter_rejects_total", "Gets of keys rejected by the key filter.", func(s Stats) int64 { return s.FilterRejects }},
+		{"gocache_filter_errors_total", "Failed key filter rebuilds.", func(s Stats) int64 { return s.FilterErrors }},
+	}
+	for _, c := range counters {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+		for _, s := range snapshots {
+			fmt.Fprintf(w, "%s{%s} %d\n", c.name, s.labels, c.value(s.stats))
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP gocache_hit_ratio Cache hits over gets.\n# TYPE gocache_hit_ratio gauge\n")
+	for _, s := range snapshots {
+		ratio := 0.0
+		if s.stats.Gets > 0 {
+			ratio = float64(s.stats.CacheHits) / float64(s.stats.Gets)
+		}
+		fmt.Fprintf(w, "gocache_hit_ratio{%s} %g\n", s.labels, ratio)
+	}
+
+	caches := []struct {
+		name, help, kind string
+		value            func(CacheStats) int64
+	}{
+		{"gocache_cache_bytes", "Bytes used by keys and values.", "gauge", func(s CacheStats) int64 { return s.Bytes }},
+		{"gocache_cache_items", "Values in the cache.", "gauge", func(s CacheStats) int64 { return s.Items }},
+		{"gocache_cache_evictions_total", "Values evicted to free space.", "counter", func(s CacheStats) int64 { return s.Evictions }},
+	}
+	for _, c := range caches {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", c.name, c.help, c.name, c.kind)
+		for _, s := range snapshots {
+			for _, which := range []string{"main", "hot"} {
+				fmt.Fprintf(w, "%s{%s,%s} %d\n", c.name, s.labels, label("cache", which), c.value(s.caches[which]))
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP gocache_load_duration_seconds Latency of the loads from peers or the Getter.\n# TYPE gocache_load_duration_seconds histogram\n")
+	for _, s := range snapshots {
+		s.group.loadLatency.write(w, "gocache_load_duration_seconds", s.labels)
+	}
+
+	// the pools sending to the same peer are summed
+	sums := make(map[peerRPC]*rpcMetrics)
+	livePeerMetrics.Range(func(key, _ interface{}) bool {
+		peer := key.(*peerMetrics)
+		peer.methods.Range(func(method, v interface{}) bool {
+			rpc := peerRPC{peer.peer, method.(string)}
+			sum, ok := sums[rpc]
+			if !ok {
+				sum = &rpcMetrics{}
+				sums[rpc] = sum
+			}
+			sum.latency.add(&v.(*rpcMetrics).latency)
+			sum.errors.Add(v.(*rpcMetrics).errors.Load())
+			return true
+		})
+		return true
+	})
+	rpcs := make([]peerRPC, 0, len(sums))
+	for rpc := range sums {
+		rpcs = append(rpcs, rpc)
+	}
+	sort.Slice(rpcs, func(i, j int) bool {
+		if rpcs[i].peer != rpcs[j].peer {
+			return rpcs[i].peer < rpcs[j].peer
+		}
+		return rpcs[i].method < rpcs[j].method
+	})
+	fmt.Fprintf(w, "# HELP gocache_peer_request_duration_seconds Latency of the requests sent to peers.\n# TYPE gocache_peer_request_duration_seconds histogram\n")
+	for _, rpc := range rpcs {
+		sums[rpc].latency.write(w, "gocache_peer_request_duration_seconds", label("peer", rpc.peer)+","+label("method", rpc.method))
+	}
+	fmt.Fprintf(w, "# HELP gocache_peer_request_errors_total Failed requests sent to peers.\n# TYPE gocache_peer_request_errors_total counter\n")
+	for _, rpc := range rpcs {
+		fmt.Fprintf(w, "gocache_peer_request_errors_total{%s,%s} %d\n", label("peer", rpc.peer), label("method", rpc.method), sums[rpc].errors.Load())
+	}
+}
@@ -0,0 +1,68 @@
+package gocache
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	scores := NewGroup("metricscores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+	scores.Get("Tom")
+	scores.Get("Tom")
+	peer := newPeerMetrics("10.0.0.1:8001")
+	defer peer.forget()
+	peer.observe("get", time.Now(), nil)
+	peer.observe("get", time.Now(), context.DeadlineExceeded)
+
+	recorder := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+	for _, line := range []string{
+		`gocache_gets_total{group="metricscores"} 2`,
+		`gocache_cache_hits_total{group="metricscores"} 1`,
+		`gocache_hit_ratio{group="metricscores"} 0.5`,
+		`gocache_cache_items{group="metricscores",cache="main"} 1`,
+		`gocache_cache_bytes{group="metricscores",cache="main"} 6`,
+		`gocache_load_duration_seconds_count{group="metricscores"} 1`,
+		`gocache_load_duration_seconds_bucket{group="metricscores",le="+Inf"} 1`,
+		`gocache_peer_request_duration_seconds_count{peer="10.0.0.1:8001",method="get"} 2`,
+		`gocache_peer_request_errors_total{peer="10.0.0.1:8001",method="get"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics miss line %s", line)
+		}
+	}
+	if t.Failed() {
+		t.Log(body)
+	}
+
+	// the pools sending to the same peer are summed, a peer leaving a pool
+	// takes the metrics of this pool only
+	pool := NewHTTPPool("metrics")
+	pool.Add("10.0.0.1:8001")
+	pool.clients["10.0.0.1:8001"].metrics.observe("get", time.Now(), nil)
+	if line := `gocache_peer_request_duration_seconds_count{peer="10.0.0.1:8001",method="get"} 3`; !strings.Contains(scrape(), line+"\n") {
+		t.Fatalf("metrics miss line %s", line)
+	}
+	pool.RemovePeer("10.0.0.1:8001")
+	if line := `gocache_peer_request_duration_seconds_count{peer="10.0.0.1:8001",method="get"} 2`; !strings.Contains(scrape(), line+"\n") {
+		t.Fatalf("metrics miss line %s", line)
+	}
+	peer.forget()
+	if strings.Contains(scrape(), `peer="10.0.0.1:8001"`) {
+		t.Fatalf("metrics of the removed peer are still there")
+	}
+}
+
+// scrape returns the metrics served by MetricsHandler
+func scrape() string {
+	recorder := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	return recorder.Body.String()
+}
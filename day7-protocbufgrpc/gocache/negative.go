@@ -0,0 +1,36 @@
+package gocache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by a Getter for a key which doesnt exist, possibly wrapped.
+// The group caches it as a negative entry for the negative TTL, so a scan of keys
+// which dont exist doesnt reach the origin every time. Peers send it as
+// codes.NotFound over grpc and 404 over http, and the callers cache it too
+// instead of loading the key themselves.
+var ErrNotFound = errors.New("gocache: not found")
+
+// defaultNegativeTTL is how long ErrNotFound is cached by default
+const defaultNegativeTTL = 10 * time.Second
+
+// WithNegativeTTL sets how long ErrNotFound is cached, 0 doesnt cache it
+func WithNegativeTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.negativeTTL = ttl
+	}
+}
+
+// cacheNotFound caches key as not found in c for the negative TTL and returns the
+// negative entry
+func (g *Group) cacheNotFound(ctx context.Context, key string, c *cache) ByteView {
+	value := ByteView{missing: true}
+	if g.negativeTTL <= 0 || (c == &g.hotCache && g.hotCacheShare <= 0) {
+		return value
+	}
+	value.e = time.Now().Add(g.negativeTTL)
+	g.cacheLoaded(ctx, key, value, c)
+	return value
+}
@@ -0,0 +1,68 @@
+package gocache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestNegativeCache(t *testing.T) {
+	loads := 0
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		if v, ok := db[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("%s key not exist: %w", key, ErrNotFound)
+	})
+	g := NewGroup("negative", 2<<10, getter, WithNegativeTTL(20*time.Millisecond))
+	for i := 0; i < 3; i++ {
+		if _, err := g.Get("unknown"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expect ErrNotFound, got %v", err)
+		}
+	}
+	if loads != 1 || g.Stats().NegativeHits != 2 {
+		t.Fatalf("not found should be cached, %d loads, stats %+v", loads, g.Stats())
+	}
+	// the negative entry expires with its own TTL
+	time.Sleep(30 * time.Millisecond)
+	g.Get("unknown")
+	if loads != 2 {
+		t.Fatalf("expired negative entry should be loaded again, %d loads", loads)
+	}
+	// Set replaces it
+	g.Set(context.Background(), "unknown", []byte("1"))
+	if v, err := g.Get("unknown"); err != nil || v.String() != "1" {
+		t.Fatalf("expect the value set, got %q %v", v.String(), err)
+	}
+
+	// the other errors are not cached
+	loads = 0
+	g = NewGroup("negative-off", 2<<10, getter, WithNegativeTTL(0))
+	g.Get("unknown")
+	g.Get("unknown")
+	if loads != 2 {
+		t.Fatalf("negative caching is off, %d loads", loads)
+	}
+}
+
+// a peer answering not found is not asked again and the key not loaded locally
+func TestNegativeCacheRemote(t *testing.T) {
+	owner := &slowPeer{err: fmt.Errorf("peer: %w", ErrNotFound)}
+	loads := 0
+	g := NewGroup("negative-remote", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(key), nil
+	}))
+	g.RegisterNodes(replicaPeers{owner})
+	for i := 0; i < 3; i++ {
+		if _, err := g.Get("unknown"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expect ErrNotFound, got %v", err)
+		}
+	}
+	if owner.gets.Load() != 1 || loads != 0 {
+		t.Fatalf("owner asked %d times, %d local loads", owner.gets.Load(), loads)
+	}
+}
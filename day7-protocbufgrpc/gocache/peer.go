@@ -1,6 +1,9 @@
 package gocache
 
-import pb "gocache/gocachepb"
+import (
+	"context"
+	pb "gocache/gocachepb"
+)
 
 // PeerPicker is the interface that must be implemented by gocahe to locate
 // the peer that owns a specific key.
@@ -8,8 +11,76 @@ type PeerPicker interface {
 	PickPeer(key string) (peer PeerClient, ok bool)
 }
 
+// ReplicaPicker is a PeerPicker placing each key on several peers, see WithReplicas
+type ReplicaPicker interface {
+	PeerPicker
+	// PickPeers returns up to n peers holding key, the owner first then the ones
+	// taking over when it's down. A nil peer is this node.
+	PickPeers(key string, n int) []PeerClient
+}
+
+// BroadcastPicker is a PeerPicker listing all its peers, Set and Remove use it
+// to drop the hot copies of the key the other nodes hold
+type BroadcastPicker interface {
+	PeerPicker
+	// AllPeers returns the clients of all the peers but this node
+	AllPeers() []PeerClient
+}
+
+var (
+	_ ReplicaPicker   = (*GrpcPool)(nil)
+	_ ReplicaPicker   = (*HTTPPool)(nil)
+	_ BroadcastPicker = (*GrpcPool)(nil)
+	_ BroadcastPicker = (*HTTPPool)(nil)
+)
+
 // PeerGetter is the interface that must be implemented by a peer.
 type PeerClient interface {
 	// Request(group string, key string) error
-	Get(in *pb.Request, out *pb.Response) error
+	// ctx deadline and cancellation are sent along to the remote peer
+	Get(ctx context.Context, in *pb.Request, out *pb.Response) error
+	// Set stores the value on the peer
+	Set(ctx context.Context, in *pb.SetRequest) error
+	// Delete removes the key from the peer cache
+	Delete(ctx context.Context, in *pb.Request) error
+}
+
+// MultiPeerClient is a PeerClient getting many keys in one request, see Group.GetMany
+type MultiPeerClient interface {
+	PeerClient
+	// GetMulti gets the keys of in, the values of the keys found go into out
+	GetMulti(ctx context.Context, in *pb.MultiRequest, out *pb.MultiResponse) error
+}
+
+var (
+	_ MultiPeerClient = (*grpcClient)(nil)
+	_ MultiPeerClient = (*httpClient)(nil)
+)
+
+// Membership is implemented by the pools whose peer set can change at runtime,
+// only the keys moving between the changed peers and the others change owner
+type Membership interface {
+	AddPeer(peers ...string)
+	RemovePeer(peers ...string)
+	SetPeers(peers ...string)
+	Peers() []string
+}
+
+var (
+	_ Membership = (*GrpcPool)(nil)
+	_ Membership = (*HTTPPool)(nil)
+)
+
+// peerRequestKey marks the ctx of the requests received from peers
+type peerRequestKey struct{}
+
+// withPeerRequest marks ctx as a request from a peer, the key is loaded by this node
+// and not routed again, with bounded loads a peer may send keys this node doesn't own
+func withPeerRequest(ctx context.Context) context.Context {
+	return context.WithValue(ctx, peerRequestKey{}, true)
+}
+
+func isPeerRequest(ctx context.Context) bool {
+	fromPeer, _ := ctx.Value(peerRequestKey{}).(bool)
+	return fromPeer
 }
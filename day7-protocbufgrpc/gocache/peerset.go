@@ -0,0 +1,429 @@
+package gocache
+
+import (
+	"context"
+	"gocache/consistenthash"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// poolClient is the client of a peer of a peerSet, grpcClient or httpClient
+type poolClient interface {
+	PeerClient
+	// state is what the set keeps about the peer, see peerState
+	state() *peerState
+	// probe sends a health check to the peer and reports the result to its breaker
+	probe(ctx context.Context)
+	// Close releases the client once the peer left the set
+	Close() error
+}
+
+// peerState is the part of a client the set looks at: its breaker and its load
+type peerState struct {
+	// addr is the peer name, also the peer label of the metrics
+	addr string
+	// breaker fails the requests fast while the peer is down
+	breaker *breaker
+	// metrics of the rpcs sent to the peer
+	metrics *peerMetrics
+	// inflight counts the gets sent to the peer and not answered yet,
+	// reported is the load the peer sent with its last answer at reportedAt
+	inflight   atomic.Int64
+	reported   atomic.Int64
+	reportedAt atomic.Int64
+}
+
+func (s *peerState) state() *peerState {
+	return s
+}
+
+// reportedLoadTTL is how long the load reported by a peer is trusted, so a peer
+// we stopped sending to because it was busy is tried again
+const reportedLoadTTL = time.Second
+
+// load is the load of the peer seen from this node
+func (s *peerState) load() int64 {
+	load := s.inflight.Load()
+	if time.Since(time.Unix(0, s.reportedAt.Load())) < reportedLoadTTL {
+		load += s.reported.Load()
+	}
+	return load
+}
+
+// report keeps the load the peer sent with its answer
+func (s *peerState) report(load int64) {
+	s.reported.Store(load)
+	s.reportedAt.Store(time.Now().UnixNano())
+}
+
+// guard sends the request with the peer timeout unless the breaker is open, then
+// reports whether the peer answered to the breaker
+func (s *peerState) guard(ctx context.Context, rpc func(ctx context.Context) error) error {
+	if !s.breaker.allow() {
+		return ErrPeerUnavailable
+	}
+	rpcCtx, cancel := withPeerTimeout(ctx)
+	defer cancel()
+	err := rpc(rpcCtx)
+	if err != nil && ctx.Err() != nil {
+		s.breaker.abort()
+		return err
+	}
+	s.breaker.done(!peerFailed(ctx, err))
+	return err
+}
+
+// peerSet is the membership shared by GrpcPool and HTTPPool: the placement of
+// the keys on the peers and a client per peer, whose breaker and health probes
+// decide whether it's picked. Only the clients differ, newClient creates them.
+type peerSet[C poolClient] struct {
+	// this peer's name, it gets its own keys locally
+	base string
+	// newClient creates the client of a peer added to the set
+	newClient  func(state *peerState) C
+	mu         sync.Mutex               // guards placement, clients and the settings
+	placement  consistenthash.Placement // places the keys on the peers, the hash ring by default
+	clients    map[string]C             // client of each peer by name
+	stopHealth context.CancelFunc       // stops the health probes, see SetHealthCheck
+	// loadEpsilon turns on the bounded loads of PickPeer when > 0
+	loadEpsilon float64
+	// serving counts the gets from peers in flight, the load of this node
+	serving atomic.Int64
+	// settings of the circuit breakers of the peers, see SetCircuitBreaker
+	breakerFailures int
+	breakerCooldown time.Duration
+}
+
+// newPeerSet creates the empty set of the pool of base, newClient creates the
+// clients of the peers added to it
+func newPeerSet[C poolClient](base string, newClient func(state *peerState) C) *peerSet[C] {
+	return &peerSet[C]{
+		base:            base,
+		newClient:       newClient,
+		breakerFailures: defaultBreakerFailures,
+		breakerCooldown: defaultBreakerCooldown,
+	}
+}
+
+// Add replaces the whole peer set, same as SetPeers
+func (s *peerSet[C]) Add(peers ...string) {
+	s.SetPeers(peers...)
+}
+
+// SetPeers replaces the whole peer set, clients of peers that are still in the set
+// are kept and the ones of removed peers are closed.
+// The peers already in the set keep their weight, the new ones have weight 1.
+func (s *peerSet[C]) SetPeers(peers ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	weights := make(map[string]int, len(peers))
+	for _, peer := range peers {
+		weights[peer] = 1
+		if _, ok := s.clients[peer]; ok {
+			weights[peer] = s.placement.Weight(peer)
+		}
+	}
+	s.setPeers(weights)
+}
+
+// SetPeersWeighted replaces the whole peer set with the peers and their weights,
+// see AddPeerWeighted
+func (s *peerSet[C]) SetPeersWeighted(peers map[string]int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setPeers(peers)
+}
+
+// setPeers removes the peers not in peers and adds or reweights the others, s.mu must be held
+func (s *peerSet[C]) setPeers(peers map[string]int) {
+	for peer := range s.clients {
+		if _, ok := peers[peer]; !ok {
+			s.removePeer(peer)
+		}
+	}
+	for peer, weight := range peers {
+		s.addPeer(peer, weight)
+	}
+}
+
+// AddPeer adds peers into the ring in place, the keys moving to them are the
+// only ones changing owner. Existing peers are ignored.
+func (s *peerSet[C]) AddPeer(peers ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, peer := range peers {
+		if _, ok := s.clients[peer]; !ok {
+			s.addPeer(peer, 1)
+		}
+	}
+}
+
+// AddPeerWeighted adds peer with weight times the virtual nodes of AddPeer, so it
+// owns about weight times the keys, e.g. Group.Weight of the peer.
+// The weight of a peer already in the set is changed in place.
+func (s *peerSet[C]) AddPeerWeighted(peer string, weight int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addPeer(peer, weight)
+}
+
+// RemovePeer removes peers from the ring in place and closes their clients
+func (s *peerSet[C]) RemovePeer(peers ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, peer := range peers {
+		s.removePeer(peer)
+	}
+}
+
+// Peers returns the sorted peer set
+func (s *peerSet[C]) Peers() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	peers := make([]string, 0, len(s.clients))
+	for peer := range s.clients {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+// addPeer adds peer to the ring with a client ready to send requests, or changes
+// its weight if it's already there, s.mu must be held
+func (s *peerSet[C]) addPeer(peer string, weight int) {
+	if s.placement == nil {
+		s.placement = consistenthash.New(defaultReplicas, nil)
+		s.setBoundedLoad()
+	}
+	if s.clients == nil {
+		s.clients = make(map[string]C)
+	}
+	s.placement.AddWeighted(peer, weight)
+	if _, ok := s.clients[peer]; !ok {
+		s.clients[peer] = s.newClient(&peerState{
+			addr:    peer,
+			breaker: newBreaker(s.breakerFailures, s.breakerCooldown),
+			metrics: newPeerMetrics(peer),
+		})
+	}
+}
+
+// removePeer removes peer from the ring and closes its client, s.mu must be held
+func (s *peerSet[C]) removePeer(peer string) {
+	client, ok := s.clients[peer]
+	if !ok {
+		return
+	}
+	s.placement.Remove(peer)
+	delete(s.clients, peer)
+	client.Close()
+	client.state().metrics.forget()
+}
+
+// close stops the health probes and closes the clients of all the peers
+func (s *peerSet[C]) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopHealth != nil {
+		s.stopHealth()
+		s.stopHealth = nil
+	}
+	for peer := range s.clients {
+		s.removePeer(peer)
+	}
+	s.placement = nil
+}
+
+// SetBoundedLoad turns on consistent hashing with bounded loads, see
+// consistenthash.SetBoundedLoad. A key whose owner is busier than (1+epsilon)
+// times the average goes to the next peer clockwise. The load of a peer is the
+// gets in flight to it plus the gets in flight it reported with its last answer,
+// the load of this node is the gets it's serving for peers.
+// epsilon 0 turns it off. Only the default HashRing placement supports it.
+func (s *peerSet[C]) SetBoundedLoad(epsilon float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loadEpsilon = epsilon
+	if s.placement != nil {
+		s.setBoundedLoad()
+	}
+}
+
+// setBoundedLoad applies loadEpsilon to the ring, s.mu must be held
+func (s *peerSet[C]) setBoundedLoad() {
+	ring, ok := s.placement.(*consistenthash.HashRing)
+	if !ok {
+		return
+	}
+	if s.loadEpsilon <= 0 {
+		ring.SetBoundedLoad(0, nil)
+		return
+	}
+	// the ring is only used with s.mu held, so is this func
+	ring.SetBoundedLoad(s.loadEpsilon, func(peer string) int64 {
+		if peer == s.base {
+			return s.serving.Load()
+		}
+		if client, ok := s.clients[peer]; ok {
+			return client.state().load()
+		}
+		return 0
+	})
+}
+
+// SetPlacement replaces how the keys are placed on the peers, the consistent hash
+// ring by default, e.g. consistenthash.NewRendezvous(). The peers are moved into
+// placement with their weights.
+func (s *peerSet[C]) SetPlacement(placement consistenthash.Placement) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for peer := range s.clients {
+		placement.AddWeighted(peer, s.placement.Weight(peer))
+	}
+	s.placement = placement
+	s.setBoundedLoad()
+}
+
+// SetCircuitBreaker sets when a peer is skipped: after failures consecutive
+// requests or health probes failed because it's down or too slow, PickPeer moves
+// its keys to the next peer for cooldown, then a single trial request or a probe
+// decides whether it's back. failures 0 turns the breakers off.
+func (s *peerSet[C]) SetCircuitBreaker(failures int, cooldown time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.breakerFailures, s.breakerCooldown = failures, cooldown
+	for _, client := range s.clients {
+		client.state().breaker.set(failures, cooldown)
+	}
+}
+
+// SetHealthCheck probes the peers every interval, so a dead peer is skipped
+// before a request waits for it and a recovered one is picked again without a
+// trial request. 0 stops the probes.
+func (s *peerSet[C]) SetHealthCheck(interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopHealth != nil {
+		s.stopHealth()
+		s.stopHealth = nil
+	}
+	if interval <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopHealth = cancel
+	go s.healthCheck(ctx, interval)
+}
+
+// healthCheck probes all the peers every interval until ctx is done
+func (s *peerSet[C]) healthCheck(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		clients := s.peerClients()
+		var wg sync.WaitGroup
+		for _, client := range clients {
+			wg.Add(1)
+			go func(client C) {
+				defer wg.Done()
+				client.probe(ctx)
+			}(client)
+		}
+		wg.Wait()
+	}
+}
+
+// peerClients returns the clients of all the peers but this node
+func (s *peerSet[C]) peerClients() []C {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clients := make([]C, 0, len(s.clients))
+	for peer, client := range s.clients {
+		if peer != s.base {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+// healthy reports whether PickPeer may pick peer, s.mu must be held
+func (s *peerSet[C]) healthy(peer string) bool {
+	client, ok := s.clients[peer]
+	return peer == s.base || !ok || client.state().breaker.healthy()
+}
+
+// healthyPeers returns up to n peers of key in the placement order, the unhealthy
+// ones are skipped so the next ones take their keys, s.mu must be held
+func (s *peerSet[C]) healthyPeers(key string, n int) []string {
+	var peers []string
+	for _, peer := range s.placement.GetN(key, len(s.clients)) {
+		if len(peers) == n {
+			break
+		}
+		if s.healthy(peer) {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// implements the peerPicker interface methods
+// all peers are stored in ring s.clients
+// a peer whose circuit breaker is open is skipped, the next one owns its keys
+func (s *peerSet[C]) PickPeer(key string) (PeerClient, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.placement == nil {
+		return nil, false
+	}
+	// based on the key, we select the node/peer on the ring, consistent hash makes sure certains key belongs to one node
+	vnode := s.placement.Get(key)
+	if !s.healthy(vnode) {
+		vnode = ""
+		if peers := s.healthyPeers(key, 1); len(peers) > 0 {
+			vnode = peers[0]
+		}
+	}
+	if vnode != "" && vnode != s.base {
+		// vnode is not myself
+		return s.clients[vnode], true
+	}
+	// no peer picked, get locally myself
+	return nil, false
+}
+
+// PickPeers returns the peers of the n replicas of key in the placement order,
+// nil for this node. The unhealthy peers are skipped like in PickPeer.
+func (s *peerSet[C]) PickPeers(key string, n int) []PeerClient {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.placement == nil {
+		return nil
+	}
+	var peers []PeerClient
+	for _, node := range s.healthyPeers(key, n) {
+		if node == s.base {
+			peers = append(peers, nil)
+			continue
+		}
+		peers = append(peers, s.clients[node])
+	}
+	return peers
+}
+
+// AllPeers returns the clients of all the peers but this node
+func (s *peerSet[C]) AllPeers() []PeerClient {
+	clients := s.peerClients()
+	peers := make([]PeerClient, len(clients))
+	for i, client := range clients {
+		peers[i] = client
+	}
+	return peers
+}
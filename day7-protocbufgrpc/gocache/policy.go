@@ -0,0 +1,91 @@
+package gocache
+
+import (
+	"gocache/arc"
+	"gocache/lfu"
+	"gocache/lru"
+	"gocache/tinylfu"
+	"time"
+)
+
+// EvictionPolicy is the single threaded cache the group caches are built on,
+// it must drop entries once their bytes are over maxBytes and call onEvicted
+// for each entry it evicts or removes, like lru.Cache
+type EvictionPolicy interface {
+	Get(key string) (value ByteView, ok bool)
+	AddWithExpire(key string, value ByteView, expire time.Time)
+	Remove(key string)
+	// RemoveOldest evicts the entry the policy values the least
+	RemoveOldest()
+	RemoveExpired() int
+	Len() int
+	Bytes() int64
+	Evictions() int64
+}
+
+// Policy creates an EvictionPolicy, maxBytes 0 or less means no limit
+type Policy func(maxBytes int64, onEvicted func(string, ByteView)) EvictionPolicy
+
+// LRU evicts the least recently used entry, the default policy
+func LRU(maxBytes int64, onEvicted func(string, ByteView)) EvictionPolicy {
+	return lru.NewWithSize[string, ByteView](maxBytes, entryBytes, onEvicted)
+}
+
+// entryBytes is the size of an entry, the bytes of its key and value like lru.New
+func entryBytes(key string, value ByteView) int64 {
+	return int64(len(key) + value.Len())
+}
+
+// LFU evicts the least frequently used entry
+func LFU(maxBytes int64, onEvicted func(string, ByteView)) EvictionPolicy {
+	return valuePolicy{lfu.New(maxBytes, valueEvicted(onEvicted))}
+}
+
+// ARC balances recency and frequency and resists scans
+func ARC(maxBytes int64, onEvicted func(string, ByteView)) EvictionPolicy {
+	return valuePolicy{arc.New(maxBytes, valueEvicted(onEvicted))}
+}
+
+// TinyLFU only admits entries more frequent than the ones they'd evict,
+// the best hit ratio on skewed workloads with scans
+func TinyLFU(maxBytes int64, onEvicted func(string, ByteView)) EvictionPolicy {
+	return valuePolicy{tinylfu.New(maxBytes, valueEvicted(onEvicted))}
+}
+
+// valueCache is a cache of lru.Values, like lfu, arc and tinylfu
+type valueCache interface {
+	Get(key string) (value lru.Value, ok bool)
+	AddWithExpire(key string, value lru.Value, expire time.Time)
+	Remove(key string)
+	RemoveOldest()
+	RemoveExpired() int
+	Len() int
+	Bytes() int64
+	Evictions() int64
+}
+
+// valuePolicy is an EvictionPolicy on a valueCache, it only ever holds ByteViews
+type valuePolicy struct {
+	valueCache
+}
+
+func (p valuePolicy) Get(key string) (ByteView, bool) {
+	if v, ok := p.valueCache.Get(key); ok {
+		return v.(ByteView), true
+	}
+	return ByteView{}, false
+}
+
+func (p valuePolicy) AddWithExpire(key string, value ByteView, expire time.Time) {
+	p.valueCache.AddWithExpire(key, value, expire)
+}
+
+// valueEvicted passes the ByteViews evicted by a valueCache to onEvicted
+func valueEvicted(onEvicted func(string, ByteView)) func(string, lru.Value) {
+	if onEvicted == nil {
+		return nil
+	}
+	return func(key string, value lru.Value) {
+		onEvicted(key, value.(ByteView))
+	}
+}
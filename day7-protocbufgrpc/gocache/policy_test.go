@@ -0,0 +1,145 @@
+package gocache
+
+import (
+	"fmt"
+	"gocache/lru"
+	"gocache/tinylfu"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+var policies = []struct {
+	name   string
+	policy Policy
+}{
+	{"LRU", LRU},
+	{"LFU", LFU},
+	{"ARC", ARC},
+	{"TinyLFU", TinyLFU},
+}
+
+var (
+	tracesOnce sync.Once
+	traces     map[string][]string
+)
+
+// recordTraces records the traces once with a fixed seed, so every policy replays the same keys
+func recordTraces() map[string][]string {
+	tracesOnce.Do(func() {
+		traces = map[string][]string{
+			"zipf":     zipfTrace(rand.New(rand.NewSource(1)), 200000, 10000),
+			"zipfScan": zipfScanTrace(200000, 10000),
+			"loop":     loopTrace(200000, 1200),
+		}
+	})
+	return traces
+}
+
+// zipfTrace asks n keys out of keys with a skewed popularity, like most caches see
+func zipfTrace(r *rand.Rand, n int, keys uint64) []string {
+	zipf := rand.NewZipf(r, 1.1, 1, keys-1)
+	trace := make([]string, n)
+	for i := range trace {
+		trace[i] = fmt.Sprintf("key%d", zipf.Uint64())
+	}
+	return trace
+}
+
+// zipfScanTrace is zipfTrace with a scan of keys seen only once every 10000 requests
+func zipfScanTrace(n int, keys uint64) []string {
+	trace := zipfTrace(rand.New(rand.NewSource(2)), n, keys)
+	scan := 0
+	for i := 0; i+2000 < len(trace); i += 10000 {
+		for j := i; j < i+2000; j++ {
+			trace[j] = fmt.Sprintf("scan%d", scan)
+			scan++
+		}
+	}
+	return trace
+}
+
+// loopTrace loops over keys, a bit more than fit in the cache, LRU never hits
+func loopTrace(n, keys int) []string {
+	trace := make([]string, n)
+	for i := range trace {
+		trace[i] = fmt.Sprintf("key%d", i%keys)
+	}
+	return trace
+}
+
+// hitRatio replays trace on a policy holding about 1000 values of 16 bytes,
+// a miss adds the key like Group.load
+func hitRatio(policy Policy, trace []string) float64 {
+	value := ByteView{b: make([]byte, 16)}
+	c := policy(1000*(16+8), nil)
+	hits := 0
+	for _, key := range trace {
+		if _, ok := c.Get(key); ok {
+			hits++
+			continue
+		}
+		c.AddWithExpire(key, value, value.Expire())
+	}
+	return float64(hits) / float64(len(trace))
+}
+
+func TestPolicyScanResistance(t *testing.T) {
+	trace := recordTraces()["zipfScan"]
+	lru := hitRatio(LRU, trace)
+	for _, policy := range []string{"ARC", "TinyLFU"} {
+		for _, p := range policies {
+			if p.name == policy {
+				if ratio := hitRatio(p.policy, trace); ratio <= lru {
+					t.Errorf("%s hit ratio %.3f should beat LRU %.3f with scans", policy, ratio, lru)
+				}
+			}
+		}
+	}
+}
+
+func TestGroupEvictionPolicy(t *testing.T) {
+	loadCounts := make(map[string]int)
+	group := NewGroup("policy", 1000*(16+8), GetterFunc(func(key string) ([]byte, error) {
+		loadCounts[key]++
+		return make([]byte, 16), nil
+	}), WithEvictionPolicy(TinyLFU))
+	for _, key := range recordTraces()["zipfScan"][:20000] {
+		if _, err := group.Get(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := group.CacheStats(MainCache); stats.Bytes > group.cacheBytes || stats.Evictions == 0 {
+		t.Fatalf("expect evictions within %d bytes, got %+v", group.cacheBytes, stats)
+	}
+	if p, ok := group.mainCache.shards[0].policy.(valuePolicy); !ok {
+		t.Fatalf("expect the main cache built on TinyLFU, got %T", group.mainCache.shards[0].policy)
+	} else if _, ok := p.valueCache.(*tinylfu.Cache); !ok {
+		t.Fatalf("expect the main cache built on TinyLFU, got %T", p.valueCache)
+	}
+
+	// the default policy holds the ByteViews in the generic lru.Cache
+	group = NewGroup("policy-lru", 1000*(16+8), GetterFunc(func(key string) ([]byte, error) {
+		return make([]byte, 16), nil
+	}))
+	group.Get("Tom")
+	if _, ok := group.mainCache.shards[0].policy.(*lru.Cache[string, ByteView]); !ok {
+		t.Fatalf("expect the main cache built on lru.Cache[string, ByteView], got %T", group.mainCache.shards[0].policy)
+	}
+}
+
+// go test -run=^$ -bench=Policy reports the hit ratio of each policy on each trace
+func BenchmarkPolicy(b *testing.B) {
+	traces := recordTraces()
+	for _, trace := range []string{"zipf", "zipfScan", "loop"} {
+		for _, p := range policies {
+			b.Run(trace+"/"+p.name, func(b *testing.B) {
+				var ratio float64
+				for i := 0; i < b.N; i++ {
+					ratio = hitRatio(p.policy, traces[trace])
+				}
+				b.ReportMetric(ratio*100, "hit%")
+			})
+		}
+	}
+}
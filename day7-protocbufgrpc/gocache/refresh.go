@@ -0,0 +1,71 @@
+package gocache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// refreshTimeout bounds the background reloads, nobody waits for them
+const refreshTimeout = 10 * time.Second
+
+// staleRemoteTTL is how long a stale value answered by its owner is cached here,
+// the owner should have reloaded it by then
+const staleRemoteTTL = time.Second
+
+// WithRefreshAhead reloads a value in background when it's asked for less than
+// window before it expires, so the popular keys are reloaded before they expire
+// and their callers never wait for the load
+func WithRefreshAhead(window time.Duration) GroupOption {
+	return func(g *Group) {
+		g.refreshAhead = window
+	}
+}
+
+// WithStaleWhileRevalidate keeps the expired values for grace more, a Get in the
+// meantime returns the stale value right away while a single reload runs in background
+func WithStaleWhileRevalidate(grace time.Duration) GroupOption {
+	return func(g *Group) {
+		g.mainCache.grace = grace
+		g.hotCache.grace = grace
+	}
+}
+
+// revalidate starts the reload of a value served from the cache when it's stale
+// or about to expire, see WithStaleWhileRevalidate and WithRefreshAhead
+func (g *Group) revalidate(key string, v ByteView, hot bool) {
+	if v.e.IsZero() {
+		return
+	}
+	switch left := time.Until(v.e); {
+	case left < 0:
+		g.stats.staleHits.Add(1)
+		g.refresh(key, hot, &g.stats.revalidations)
+	case left < g.refreshAhead:
+		g.refresh(key, hot, &g.stats.refreshAheads)
+	}
+}
+
+// refresh reloads key in background unless it's already reloading, the load is
+// shared with the Gets of key through singleflight
+func (g *Group) refresh(key string, hot bool, counter *atomic.Int64) {
+	if _, loading := g.refreshing.LoadOrStore(key, struct{}{}); loading {
+		return
+	}
+	counter.Add(1)
+	go func() {
+		defer g.refreshing.Delete(key)
+		ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+		defer cancel()
+		ctx = g.loadStarted(ctx)
+		value, err := g.load(ctx, key)
+		if err != nil {
+			g.stats.refreshErrors.Add(1)
+			return
+		}
+		// a remote value only goes into the hot cache by chance, replace the old copy
+		if hot {
+			g.cacheLoaded(ctx, key, value, &g.hotCache)
+		}
+	}()
+}
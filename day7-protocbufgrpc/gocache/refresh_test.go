@@ -0,0 +1,122 @@
+package gocache
+
+import (
+	"context"
+	"fmt"
+	pb "gocache/gocachepb"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// versionGetter returns key-v1, key-v2... one version per load
+func versionGetter(loads *atomic.Int64) Getter {
+	return GetterFunc(func(key string) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s-v%d", key, loads.Add(1))), nil
+	})
+}
+
+// waitValue waits until g has the value expect for key in cache
+func waitValue(t *testing.T, g *Group, key, expect string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if v, ok := g.mainCache.get(key); ok && v.String() == expect {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s is not reloaded to %s", key, expect)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	var loads atomic.Int64
+	g := NewGroup("swr", 2<<10, versionGetter(&loads),
+		WithTTL(20*time.Millisecond), WithStaleWhileRevalidate(time.Hour))
+	defer g.Close()
+	g.Get("Tom")
+	time.Sleep(30 * time.Millisecond)
+
+	// the expired value is served at once, a single reload runs
+	for i := 0; i < 5; i++ {
+		if v, err := g.Get("Tom"); err != nil || (v.String() != "Tom-v1" && v.String() != "Tom-v2") {
+			t.Fatalf("expect the stale value, got %q %v", v.String(), err)
+		}
+	}
+	waitValue(t, g, "Tom", "Tom-v2")
+	stats := g.Stats()
+	if loads.Load() != 2 || stats.Revalidations != 1 || stats.StaleHits == 0 {
+		t.Fatalf("%d loads, stats %+v", loads.Load(), stats)
+	}
+	if v, _ := g.Get("Tom"); v.String() != "Tom-v2" {
+		t.Fatalf("expect the reloaded value, got %q", v.String())
+	}
+
+	// without grace the expired value is loaded again by the caller
+	var noGraceLoads atomic.Int64
+	g = NewGroup("noswr", 2<<10, versionGetter(&noGraceLoads), WithTTL(10*time.Millisecond))
+	defer g.Close()
+	g.Get("Tom")
+	time.Sleep(20 * time.Millisecond)
+	if v, _ := g.Get("Tom"); v.String() != "Tom-v2" || g.Stats().StaleHits != 0 {
+		t.Fatalf("expect a fresh value, got %q", v.String())
+	}
+}
+
+func TestRefreshAhead(t *testing.T) {
+	var loads atomic.Int64
+	g := NewGroup("refreshahead", 2<<10, versionGetter(&loads),
+		WithTTL(100*time.Millisecond), WithRefreshAhead(80*time.Millisecond))
+	defer g.Close()
+	g.Get("Tom")
+	// not close to expiry yet
+	g.Get("Tom")
+	if g.Stats().RefreshAheads != 0 {
+		t.Fatalf("refreshed too early")
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if v, _ := g.Get("Tom"); v.String() != "Tom-v1" {
+		t.Fatalf("expect the current value, got %q", v.String())
+	}
+	// reloaded before it expires
+	waitValue(t, g, "Tom", "Tom-v2")
+	if stats := g.Stats(); stats.RefreshAheads != 1 || stats.Loads != 1 {
+		t.Fatalf("callers should not load, stats %+v", stats)
+	}
+}
+
+// stalePeer answers the values as expired a minute ago, like an owner serving
+// them within its grace
+type stalePeer struct {
+	gets atomic.Int64
+}
+
+func (p *stalePeer) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	p.gets.Add(1)
+	out.Value = []byte(in.Key + "-stale")
+	out.Expire = time.Now().Add(-time.Minute).UnixNano()
+	return nil
+}
+
+func (p *stalePeer) Set(ctx context.Context, in *pb.SetRequest) error { return nil }
+
+func (p *stalePeer) Delete(ctx context.Context, in *pb.Request) error { return nil }
+
+// a stale value of the owner is kept in the hot cache for a while
+func TestStaleRemoteValue(t *testing.T) {
+	owner := &stalePeer{}
+	g := NewGroup("staleremote", 2<<10, versionGetter(&atomic.Int64{}), WithStaleWhileRevalidate(time.Hour))
+	g.hotCacheOdds = 1
+	g.RegisterNodes(replicaPeers{owner})
+	for i := 0; i < 3; i++ {
+		if v, err := g.Get("Tom"); err != nil || v.String() != "Tom-stale" {
+			t.Fatalf("expect the stale value of the owner, got %q %v", v.String(), err)
+		}
+	}
+	if owner.gets.Load() != 1 || g.Stats().Revalidations != 0 {
+		t.Fatalf("owner asked %d times, stats %+v", owner.gets.Load(), g.Stats())
+	}
+}
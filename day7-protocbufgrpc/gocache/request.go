@@ -0,0 +1,163 @@
+package gocache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// RequestPolicy is how a Group retries and hedges the loads from its peers, see
+// WithRequestPolicy. The candidates of a key are its replicas in order, then this
+// node which loads it through the Getter.
+type RequestPolicy struct {
+	// Retries is how many failed requests are sent again to the next candidate
+	Retries int
+	// HedgeDelay sends the request to the next candidate too when the ones in
+	// flight didnt answer after it, and so on every HedgeDelay while candidates
+	// are left. The first answer wins and the others are cancelled. 0 never hedges.
+	HedgeDelay time.Duration
+	// Budget is the retries and hedges allowed per load, e.g. 0.1 for 10% more
+	// requests, so a peer outage doesnt multiply the load on the others and on
+	// the origin. A burst of budgetBurst is allowed. 0 doesnt limit them.
+	Budget float64
+}
+
+// DefaultRequestPolicy hedges a load after 50ms and retries once, at most 10%
+// more requests
+var DefaultRequestPolicy = RequestPolicy{Retries: 1, HedgeDelay: 50 * time.Millisecond, Budget: 0.1}
+
+// budgetBurst is the retries and hedges allowed in a row, when the budget is full
+const budgetBurst = 10
+
+// WithRequestPolicy retries and hedges the loads from peers with policy. Without
+// it a failed peer load is tried on the next replica and then locally, every time.
+func WithRequestPolicy(policy RequestPolicy) GroupOption {
+	return func(g *Group) {
+		g.requestPolicy = &policy
+		g.budget = &retryBudget{ratio: policy.Budget, tokens: budgetBurst}
+	}
+}
+
+// retryBudget is a token bucket, each load deposits ratio tokens and each retry
+// or hedge takes one
+type retryBudget struct {
+	mu     sync.Mutex
+	ratio  float64
+	tokens float64
+}
+
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens += b.ratio; b.tokens > budgetBurst {
+		b.tokens = budgetBurst
+	}
+}
+
+// withdraw takes a token, false if the budget is spent
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ratio <= 0 {
+		return true
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// loadHedged loads key from the candidates peers, nil for this node, following
+// g.requestPolicy: the next candidate is asked when the ones in flight are too
+// slow, every HedgeDelay, or failed, as long as the policy and the budget allow it.
+// Like load it falls back to this node once the peers asked all failed.
+func (g *Group) loadHedged(ctx context.Context, key string, peers []PeerClient, replica bool) (ByteView, error) {
+	policy := g.requestPolicy
+	if !replica {
+		// this node is the last resort
+		peers = append(peers[:len(peers):len(peers)], nil)
+	}
+	g.budget.deposit()
+	// the requests still in flight when we return are the losers, cancel them
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		value ByteView
+		err   error
+	}
+	results := make(chan result, len(peers))
+	next, inflight := 0, 0
+	local := false
+	send := func() {
+		peer := peers[next]
+		next++
+		inflight++
+		if peer == nil {
+			local = true
+		}
+		go func() {
+			var r result
+			if peer == nil {
+				r.value, r.err = g.getLocal(ctx, key)
+			} else if r.value, r.err = g.getFromRemote(ctx, peer, key, replica); r.err == nil {
+				g.stats.peerLoads.Add(1)
+			} else if errors.Is(r.err, ErrNotFound) {
+				g.cacheNotFound(ctx, key, g.remoteCache(replica))
+			} else {
+				g.stats.peerErrors.Add(1)
+			}
+			results <- r
+		}()
+	}
+	send()
+
+	var timer *time.Timer
+	var hedge <-chan time.Time
+	if policy.HedgeDelay > 0 {
+		timer = time.NewTimer(policy.HedgeDelay)
+		defer timer.Stop()
+		hedge = timer.C
+	}
+	retries := policy.Retries
+	for {
+		select {
+		case r := <-results:
+			inflight--
+			if r.err == nil {
+				return r.value, nil
+			}
+			// not found is an answer, the others would not find it either
+			if errors.Is(r.err, ErrNotFound) {
+				return ByteView{}, r.err
+			}
+			// the caller gave up, dont try the others
+			if ctx.Err() != nil {
+				return ByteView{}, ctx.Err()
+			}
+			if next < len(peers) && retries > 0 && g.budget.withdraw() {
+				retries--
+				g.stats.peerRetries.Add(1)
+				send()
+			} else if inflight == 0 {
+				if local {
+					return ByteView{}, r.err
+				}
+				// the peers asked all failed, we get locally
+				return g.getLocal(ctx, key)
+			}
+		case <-hedge:
+			if next < len(peers) && g.budget.withdraw() {
+				g.stats.peerHedges.Add(1)
+				send()
+			}
+			if next < len(peers) {
+				timer.Reset(policy.HedgeDelay)
+			} else {
+				hedge = nil
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package gocache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "gocache/gocachepb"
+)
+
+// slowPeer answers after delay unless the request is cancelled before
+type slowPeer struct {
+	delay     time.Duration
+	err       error
+	gets      atomic.Int64
+	cancelled atomic.Int64
+}
+
+func (p *slowPeer) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	p.gets.Add(1)
+	select {
+	case <-time.After(p.delay):
+	case <-ctx.Done():
+		p.cancelled.Add(1)
+		return ctx.Err()
+	}
+	if p.err != nil {
+		return p.err
+	}
+	out.Value = []byte(in.Key + "-slow")
+	return nil
+}
+
+func (p *slowPeer) Set(ctx context.Context, in *pb.SetRequest) error { return nil }
+
+func (p *slowPeer) Delete(ctx context.Context, in *pb.Request) error { return nil }
+
+func TestHedgedLoad(t *testing.T) {
+	slow, fast := &slowPeer{delay: time.Second}, &slowPeer{}
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key + "-local"), nil
+	})
+
+	// the slow owner is hedged on its replica, which answers first
+	g := NewGroup("hedged", 2<<10, getter, WithReplicas(2),
+		WithRequestPolicy(RequestPolicy{HedgeDelay: 10 * time.Millisecond}))
+	g.RegisterNodes(replicaPeers{slow, fast})
+	start := time.Now()
+	if view, err := g.Get("Tom"); err != nil || view.String() != "Tom-slow" {
+		t.Fatalf("failed to get Tom, got %q %v", view.String(), err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("hedged load took %v", elapsed)
+	}
+	if fast.gets.Load() != 1 || g.Stats().PeerHedges != 1 {
+		t.Fatalf("replica asked %d times, %d hedges", fast.gets.Load(), g.Stats().PeerHedges)
+	}
+	// the loser is cancelled
+	deadline := time.Now().Add(time.Second)
+	for slow.cancelled.Load() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("slow request is not cancelled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// without replica the hedge is served locally
+	g = NewGroup("hedged-local", 2<<10, getter,
+		WithRequestPolicy(RequestPolicy{HedgeDelay: 10 * time.Millisecond}))
+	g.RegisterNodes(replicaPeers{slow})
+	if view, err := g.Get("Tom"); err != nil || view.String() != "Tom-local" {
+		t.Fatalf("failed to get Tom locally, got %q %v", view.String(), err)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	down := &slowPeer{err: errors.New("peer down")}
+	var localGets int
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		localGets++
+		return []byte(key), nil
+	})
+	g := NewGroup("retries", 2<<10, getter,
+		WithRequestPolicy(RequestPolicy{Retries: 1, Budget: 0.1}))
+	g.RegisterNodes(replicaPeers{down})
+
+	// the failed loads are retried until the burst is spent, then only one in
+	// ten is, the others fall back to the local source without a retry
+	for i := 0; i < 30; i++ {
+		if _, err := g.Get(fmt.Sprint(i)); err != nil {
+			t.Fatalf("failed to get %d locally: %v", i, err)
+		}
+	}
+	if stats := g.Stats(); stats.PeerRetries < budgetBurst || stats.PeerRetries > budgetBurst+4 || localGets != 30 {
+		t.Fatalf("%d retries and %d local loads, expect about %d and 30", stats.PeerRetries, localGets, budgetBurst+2)
+	}
+	if down.gets.Load() != 30 {
+		t.Fatalf("owner asked %d times, expect 30", down.gets.Load())
+	}
+}
+
+// a slow load is hedged on each of the other replicas in turn
+func TestHedgedLoadReplicas(t *testing.T) {
+	slow, slower, fast := &slowPeer{delay: time.Second}, &slowPeer{delay: time.Second}, &slowPeer{}
+	g := NewGroup("hedged-replicas", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key + "-local"), nil
+	}), WithReplicas(3), WithRequestPolicy(RequestPolicy{HedgeDelay: 10 * time.Millisecond}))
+	g.RegisterNodes(replicaPeers{slow, slower, fast})
+	if view, err := g.Get("Tom"); err != nil || view.String() != "Tom-slow" {
+		t.Fatalf("failed to get Tom, got %q %v", view.String(), err)
+	}
+	if fast.gets.Load() != 1 || g.Stats().PeerHedges != 2 {
+		t.Fatalf("last replica asked %d times, %d hedges", fast.gets.Load(), g.Stats().PeerHedges)
+	}
+}
+
+// with all the peers down a hedged load is served locally like an unhedged one
+func TestHedgedLoadPeersDown(t *testing.T) {
+	down := &slowPeer{err: errors.New("peer down")}
+	g := NewGroup("hedged-down", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key + "-local"), nil
+	}), WithReplicas(2), WithRequestPolicy(RequestPolicy{HedgeDelay: time.Second}))
+	g.RegisterNodes(replicaPeers{down, down})
+	if view, err := g.Get("Tom"); err != nil || view.String() != "Tom-local" {
+		t.Fatalf("failed to get Tom locally, got %q %v", view.String(), err)
+	}
+	if down.gets.Load() != 1 {
+		t.Fatalf("peers asked %d times, expect 1 without retries", down.gets.Load())
+	}
+}
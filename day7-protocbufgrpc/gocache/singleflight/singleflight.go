@@ -1,60 +1,264 @@
+// Portions of this file (doCall, panicError, newPanicError, errGoexit and the
+// Do, DoChan and Forget API) are adapted from golang.org/x/sync/singleflight:
+//
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file next to this one.
+
 // to solve cache Penetration, e.g. when sending huge concurrent requests ?key=Tom
 // we will send them all to local cache/source, or to remote node. It will pressure
 // the server in short time, leading to penetration.
 
 // Therefore, we use singleflight to make sure concurrent requests to the same key
-// waits for the 1st to finish with c.wg.Wait(), and then reuse the response from 1st request.
+// waits for the 1st to finish with <-c.done, and then reuse the response from 1st request.
+
+// The API follows golang.org/x/sync/singleflight: Do also reports whether the result
+// was shared, DoChan returns the result on a channel and Forget drops a key, a panic
+// or runtime.Goexit in fn is passed to the waiting callers instead of blocking them.
 
 package singleflight
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/debug"
 	"sync"
 )
 
-type Call struct {
-	wg  sync.WaitGroup
-	val interface{}
-	err error
+// errGoexit indicates the runtime.Goexit was called in fn
+var errGoexit = errors.New("runtime.Goexit was called")
+
+// A panicError is a value recovered from a panic in fn, with the stack trace of the panic
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+func (p *panicError) Error() string {
+	return fmt.Sprintf("%v\n\n%s", p.value, p.stack)
+}
+
+func (p *panicError) Unwrap() error {
+	err, ok := p.value.(error)
+	if !ok {
+		return nil
+	}
+	return err
+}
+
+func newPanicError(v interface{}) error {
+	stack := debug.Stack()
+	// The first line of the stack trace is of the form "goroutine N [status]:"
+	// but by the time the panic reaches Do the goroutine may no longer exist
+	// and its status will have changed. Trim out the misleading line.
+	if line := bytes.IndexByte(stack, '\n'); line >= 0 {
+		stack = stack[line+1:]
+	}
+	return &panicError{value: v, stack: stack}
+}
+
+// call is an in-flight or completed request
+type call struct {
+	done chan struct{} // closed once the request completes
+	val  interface{}
+	err  error
+	// dups counts the callers which joined the request, chans are the DoChan callers
+	// waiting for the result, both guarded by Group.mu
+	dups  int
+	chans []chan<- Result
+	// abandoned is set when fn of DoContext failed because the ctx of its caller
+	// is done, the waiters whose ctx is still live run it again
+	abandoned bool
 }
 
+// Group is a namespace of requests, the zero value is ready to use
 type Group struct {
-	mu   sync.Mutex // protects call from concurrent r/w
-	call map[string]*Call
+	mu   sync.Mutex       // protects call from concurrent r/w
+	call map[string]*call // lazily initialized
+}
+
+// Result holds the results of Do, so they can be passed on a channel.
+type Result struct {
+	Val    interface{}
+	Err    error
+	Shared bool
 }
 
 //	ensure that only one execution of a function with the same key is in progress at any given time.
 //
 // If a request with the same key is already in progress, other requests will wait for the result of the ongoing request instead of starting a new one.
-func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
-
+// shared reports whether v was given to multiple callers.
+func (g *Group) Do(key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	g.mu.Lock()
 	// delayed init
-	if g == nil || g.call == nil {
-		g = &Group{
-			call: make(map[string]*Call),
-		}
+	if g.call == nil {
+		g.call = make(map[string]*call)
 	}
-	g.mu.Lock()
 	// wait existing request finish and reuses
 	if c, ok := g.call[key]; ok {
+		c.dups++
 		// read call complete, unlock
 		g.mu.Unlock()
-		c.wg.Wait()
-		return c.val, c.err
+		<-c.done
+		return c.wait()
 	}
 	// Initiate a new request
-	c := new(Call)
-	c.wg.Add(1)
+	c := &call{done: make(chan struct{})}
 	g.call[key] = c
 	g.mu.Unlock() // write call complete, unlock
 
-	// send the actual request
-	c.val, c.err = fn()
-	c.wg.Done() // request complete
+	g.doCall(c, key, fn)
+	return c.val, c.err, c.dups > 0
+}
 
-	// lock and unlock to delete call with key
+// DoChan is like Do but returns a channel that will receive the
+// results when they are ready. The channel is not closed.
+func (g *Group) DoChan(key string, fn func() (interface{}, error)) <-chan Result {
+	ch := make(chan Result, 1)
+	g.mu.Lock()
+	if g.call == nil {
+		g.call = make(map[string]*call)
+	}
+	if c, ok := g.call[key]; ok {
+		c.dups++
+		c.chans = append(c.chans, ch)
+		g.mu.Unlock()
+		return ch
+	}
+	c := &call{done: make(chan struct{}), chans: []chan<- Result{ch}}
+	g.call[key] = c
+	g.mu.Unlock()
+
+	go g.doCall(c, key, fn)
+
+	return ch
+}
+
+// DoContext is like Do but deadline and cancellation aware. fn runs with the ctx of
+// the caller who initiates the request, so cancelling it stops the shared request.
+// The callers waiting for an existing request return ctx.Err() as soon as their own
+// ctx is done, if the initiator gave up first the request is sent again for them,
+// so one impatient caller doesnt fail the others.
+func (g *Group) DoContext(ctx context.Context, key string, fn func(context.Context) (interface{}, error)) (v interface{}, err error, shared bool) {
+	for {
+		g.mu.Lock()
+		if g.call == nil {
+			g.call = make(map[string]*call)
+		}
+		if c, ok := g.call[key]; ok {
+			c.dups++
+			g.mu.Unlock()
+			select {
+			case <-c.done:
+				if c.abandoned && ctx.Err() == nil {
+					continue
+				}
+				return c.wait()
+			case <-ctx.Done():
+				return nil, ctx.Err(), true
+			}
+		}
+		c := &call{done: make(chan struct{})}
+		g.call[key] = c
+		g.mu.Unlock()
+
+		g.doCall(c, key, func() (interface{}, error) {
+			v, err := fn(ctx)
+			// set before done is closed, so the waiters see it
+			c.abandoned = err != nil && ctx.Err() != nil
+			return v, err
+		})
+		return c.val, c.err, c.dups > 0
+	}
+}
+
+// Forget tells the singleflight to forget about a key. Future calls
+// to Do for this key will call the function rather than waiting for
+// an earlier call to complete.
+func (g *Group) Forget(key string) {
 	g.mu.Lock()
 	delete(g.call, key)
 	g.mu.Unlock()
+}
 
-	return c.val, c.err
+// wait returns the result of a completed call to a caller which joined it,
+// a panic or runtime.Goexit of fn happens again in the caller
+func (c *call) wait() (interface{}, error, bool) {
+	if e, ok := c.err.(*panicError); ok {
+		panic(e)
+	} else if c.err == errGoexit {
+		runtime.Goexit()
+	}
+	return c.val, c.err, true
+}
+
+// doCall handles the single call for a key, the callers are released even when
+// fn panics or calls runtime.Goexit
+func (g *Group) doCall(c *call, key string, fn func() (interface{}, error)) {
+	normalReturn := false
+	recovered := false
+
+	// use double-defer to distinguish panic from runtime.Goexit,
+	// more details see https://golang.org/cl/134395
+	defer func() {
+		// the given function invoked runtime.Goexit
+		if !normalReturn && !recovered {
+			c.err = errGoexit
+		}
+
+		// lock and unlock to delete call with key
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		close(c.done) // request complete
+		// the key may be forgotten and already used by a newer call
+		if g.call[key] == c {
+			delete(g.call, key)
+		}
+
+		if e, ok := c.err.(*panicError); ok {
+			// In order to prevent the waiting channels from being blocked forever,
+			// needs to ensure that this panic cannot be recovered.
+			if len(c.chans) > 0 {
+				go panic(e)
+				select {} // Keep this goroutine around so that it will appear in the crash dump.
+			} else {
+				panic(e)
+			}
+		} else if c.err == errGoexit {
+			// Already in the process of goexit, no need to call again
+		} else {
+			// Normal return
+			for _, ch := range c.chans {
+				ch <- Result{c.val, c.err, c.dups > 0}
+			}
+		}
+	}()
+
+	func() {
+		defer func() {
+			if !normalReturn {
+				// Ideally, we would wait to take a stack trace until we've determined
+				// whether this is a panic or a runtime.Goexit.
+				//
+				// Unfortunately, the only way we can distinguish the two is to see
+				// whether the recover stopped the goroutine from terminating, and by
+				// the time we know that, the part of the stack trace relevant to the
+				// panic has been discarded.
+				if r := recover(); r != nil {
+					c.err = newPanicError(r)
+				}
+			}
+		}()
+
+		// send the actual request
+		c.val, c.err = fn()
+		normalReturn = true
+	}()
+
+	if !normalReturn {
+		recovered = true
+	}
 }
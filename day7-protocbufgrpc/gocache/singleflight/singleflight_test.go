@@ -0,0 +1,238 @@
+package singleflight
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	var g Group
+	v, err, shared := g.Do("key", func() (interface{}, error) {
+		return "bar", nil
+	})
+	if v != "bar" || err != nil || shared {
+		t.Fatalf("Do = %v, %v, %v", v, err, shared)
+	}
+}
+
+// the zero value Group must deduplicate concurrent calls
+func TestDoDupSuppress(t *testing.T) {
+	var g Group
+	var calls int32
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "bar", nil
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	var shared int32
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			v, err, s := g.Do("key", fn)
+			if v != "bar" || err != nil {
+				t.Errorf("Do = %v, %v", v, err)
+			}
+			if s {
+				atomic.AddInt32(&shared, 1)
+			}
+		}()
+	}
+	// let all goroutines join the call before it completes
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("number of calls = %d, expect 1", got)
+	}
+	if shared != n {
+		t.Fatalf("%d callers got a shared result, expect %d", shared, n)
+	}
+}
+
+func TestDoChan(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	ch1 := g.DoChan("key", func() (interface{}, error) {
+		<-release
+		return "bar", nil
+	})
+	ch2 := g.DoChan("key", func() (interface{}, error) {
+		return "other", nil
+	})
+	close(release)
+	for _, ch := range []<-chan Result{ch1, ch2} {
+		if res := <-ch; res.Val != "bar" || res.Err != nil || !res.Shared {
+			t.Fatalf("DoChan = %+v", res)
+		}
+	}
+}
+
+func TestForget(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	first := g.DoChan("key", func() (interface{}, error) {
+		<-release
+		return 1, nil
+	})
+	g.Forget("key")
+	// a forgotten key starts a new call instead of joining the first one
+	if v, _, shared := g.Do("key", func() (interface{}, error) { return 2, nil }); v != 2 || shared {
+		t.Fatalf("Do after Forget = %v, shared %v", v, shared)
+	}
+	close(release)
+	if res := <-first; res.Val != 1 {
+		t.Fatalf("first call = %+v", res)
+	}
+}
+
+// a panic in fn reaches the waiting callers instead of blocking them forever
+func TestPanicDo(t *testing.T) {
+	var g Group
+	started := make(chan struct{})
+	fn := func() (interface{}, error) {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		panic("invalid memory address or nil pointer dereference")
+	}
+
+	const n = 5
+	var panics int32
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					atomic.AddInt32(&panics, 1)
+				}
+			}()
+			if i > 0 {
+				<-started
+				g.Do("key", func() (interface{}, error) { return nil, nil })
+				return
+			}
+			g.Do("key", fn)
+		}(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Do hangs after panic")
+	}
+	if panics != n {
+		t.Fatalf("%d callers panicked, expect %d", panics, n)
+	}
+}
+
+func TestGoexitDo(t *testing.T) {
+	var g Group
+	started := make(chan struct{})
+	waiter := make(chan error, 1)
+	go func() {
+		g.Do("key", func() (interface{}, error) {
+			close(started)
+			time.Sleep(50 * time.Millisecond)
+			runtime.Goexit()
+			return nil, nil
+		})
+	}()
+	go func() {
+		<-started
+		defer func() { waiter <- errGoexit }()
+		g.Do("key", func() (interface{}, error) { return nil, nil })
+		waiter <- nil
+	}()
+	select {
+	case err := <-waiter:
+		if err != errGoexit {
+			t.Fatalf("waiter returned normally after Goexit")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Do hangs after Goexit")
+	}
+}
+
+// the waiting callers stop waiting once their own ctx is done
+func TestDoContext(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	go g.DoContext(context.Background(), "key", func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-release
+		return "bar", nil
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err, _ := g.DoContext(ctx, "key", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("DoContext err %v, expect deadline exceeded", err)
+	}
+}
+
+// the first caller giving up doesnt fail the callers which joined it
+func TestDoContextFirstCancels(t *testing.T) {
+	var g Group
+	var calls int32
+	started := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return "bar", nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err, _ := g.DoContext(ctx, "key", fn)
+		first <- err
+	}()
+	<-started
+
+	second := make(chan Result)
+	go func() {
+		v, err, shared := g.DoContext(context.Background(), "key", fn)
+		second <- Result{v, err, shared}
+	}()
+	// wait for the second caller to join
+	for {
+		g.mu.Lock()
+		dups := g.call["key"].dups
+		g.mu.Unlock()
+		if dups > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("first caller err %v, expect canceled", err)
+	}
+	if r := <-second; r.Err != nil || r.Val != "bar" {
+		t.Fatalf("second caller got %v %v, expect bar", r.Val, r.Err)
+	}
+	if atomic.LoadInt32(&calls) != 2 {
+		t.Fatalf("fn called %d times, expect 2", calls)
+	}
+}
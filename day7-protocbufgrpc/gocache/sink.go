@@ -0,0 +1,86 @@
+package gocache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// A Sink receives the cached bytes of a value and decodes them into the caller's
+// destination, so callers dont unmarshal ByteView by hand, see Group.GetSink.
+// The bytes given to SetBytes are the cache bytes, a Sink must not keep or modify them.
+type Sink interface {
+	SetBytes(b []byte) error
+}
+
+// StringSink returns a Sink setting *s to the value
+func StringSink(s *string) Sink {
+	return stringSink{s}
+}
+
+type stringSink struct{ dst *string }
+
+func (s stringSink) SetBytes(b []byte) error {
+	*s.dst = string(b)
+	return nil
+}
+
+// BytesSink returns a Sink setting *b to a copy of the value
+func BytesSink(b *[]byte) Sink {
+	return bytesSink{b}
+}
+
+type bytesSink struct{ dst *[]byte }
+
+func (s bytesSink) SetBytes(b []byte) error {
+	*s.dst = cloneBytes(b)
+	return nil
+}
+
+// ByteViewSink returns a Sink setting *v to a view of the value without copy
+func ByteViewSink(v *ByteView) Sink {
+	return byteViewSink{v}
+}
+
+type byteViewSink struct{ dst *ByteView }
+
+func (s byteViewSink) SetBytes(b []byte) error {
+	*s.dst = ByteView{b: b}
+	return nil
+}
+
+// ProtoSink returns a Sink unmarshaling the value into m
+func ProtoSink(m proto.Message) Sink {
+	return protoSink{m}
+}
+
+type protoSink struct{ dst proto.Message }
+
+func (s protoSink) SetBytes(b []byte) error {
+	return proto.Unmarshal(b, s.dst)
+}
+
+// JSONSink returns a Sink unmarshaling the json value into v, a pointer
+func JSONSink(v interface{}) Sink {
+	return jsonSink{v}
+}
+
+type jsonSink struct{ dst interface{} }
+
+func (s jsonSink) SetBytes(b []byte) error {
+	return json.Unmarshal(b, s.dst)
+}
+
+// GetSink is GetContext decoding the value into dest
+func (g *Group) GetSink(ctx context.Context, key string, dest Sink) error {
+	view, err := g.GetContext(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err := dest.SetBytes(view.b); err != nil {
+		return fmt.Errorf("decoding %s: %v", key, err)
+	}
+	return nil
+}
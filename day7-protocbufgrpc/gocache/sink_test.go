@@ -0,0 +1,51 @@
+package gocache
+
+import (
+	"context"
+	pb "gocache/gocachepb"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSinks(t *testing.T) {
+	message, _ := proto.Marshal(&pb.Request{Group: "scores", Key: "Tom"})
+	values := map[string][]byte{
+		"string": []byte("630"),
+		"json":   []byte(`{"name":"Tom","score":630}`),
+		"proto":  message,
+	}
+	g := NewGroup("sinks", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return values[key], nil
+	}))
+	ctx := context.Background()
+
+	var s string
+	if err := g.GetSink(ctx, "string", StringSink(&s)); err != nil || s != "630" {
+		t.Fatalf("StringSink got %q %v", s, err)
+	}
+	var b []byte
+	if err := g.GetSink(ctx, "string", BytesSink(&b)); err != nil || string(b) != "630" {
+		t.Fatalf("BytesSink got %q %v", b, err)
+	}
+	// the bytes are a copy, the cached value doesnt change
+	b[0] = 'x'
+	var v ByteView
+	if err := g.GetSink(ctx, "string", ByteViewSink(&v)); err != nil || v.String() != "630" {
+		t.Fatalf("ByteViewSink got %q %v", v.String(), err)
+	}
+	var student struct {
+		Name  string `json:"name"`
+		Score int    `json:"score"`
+	}
+	if err := g.GetSink(ctx, "json", JSONSink(&student)); err != nil || student.Name != "Tom" || student.Score != 630 {
+		t.Fatalf("JSONSink got %+v %v", student, err)
+	}
+	req := &pb.Request{}
+	if err := g.GetSink(ctx, "proto", ProtoSink(req)); err != nil || req.Group != "scores" || req.Key != "Tom" {
+		t.Fatalf("ProtoSink got %v %v", req, err)
+	}
+	if err := g.GetSink(ctx, "string", JSONSink(&student)); err == nil {
+		t.Fatalf("expect a decoding error")
+	}
+}
@@ -0,0 +1,76 @@
+package gocache
+
+import "sync/atomic"
+
+// groupStats are the per-group counters, they are atomic so the hot path never
+// blocks on them and Stats can be read at any time
+type groupStats struct {
+	gets           atomic.Int64
+	cacheHits      atomic.Int64
+	loads          atomic.Int64
+	loadsDeduped   atomic.Int64
+	peerLoads      atomic.Int64
+	peerErrors     atomic.Int64
+	peerRetries    atomic.Int64
+	peerHedges     atomic.Int64
+	localLoads     atomic.Int64
+	localLoadErrs  atomic.Int64
+	serverRequests atomic.Int64
+	staleHits      atomic.Int64
+	revalidations  atomic.Int64
+	refreshAheads  atomic.Int64
+	refreshErrors  atomic.Int64
+	negativeHits   atomic.Int64
+	filterRejects  atomic.Int64
+	filterErrors   atomic.Int64
+}
+
+// Stats is a snapshot of the statistics of a Group
+type Stats struct {
+	Gets      int64 // any Get request, including from peers
+	CacheHits int64 // either main or hot cache hit
+	// Loads are the gets that missed the cache, LoadsDeduped the loads which
+	// actually ran after singleflight, Loads - LoadsDeduped callers shared a load
+	Loads          int64
+	LoadsDeduped   int64
+	PeerLoads      int64 // either remote load or remote cache hit (not an error)
+	PeerErrors     int64
+	PeerRetries    int64 // failed loads sent again to the next node, see RequestPolicy
+	PeerHedges     int64 // slow loads also sent to the next node
+	LocalLoads     int64 // total good local loads
+	LocalLoadErrs  int64 // total bad local loads
+	ServerRequests int64 // gets that came over the network from peers
+	Evictions      int64 // values evicted from main and hot cache to free space
+	StaleHits      int64 // expired values served within the grace, see WithStaleWhileRevalidate
+	Revalidations  int64 // background reloads of stale values
+	RefreshAheads  int64 // background reloads of values about to expire, see WithRefreshAhead
+	RefreshErrors  int64 // failed background reloads
+	NegativeHits   int64 // cache hits of keys not found, see ErrNotFound
+	FilterRejects  int64 // gets of keys rejected by the key filter, see WithKeyFilter
+	FilterErrors   int64 // failed key filter rebuilds
+}
+
+// Stats returns a snapshot of the group statistics
+func (g *Group) Stats() Stats {
+	return Stats{
+		Gets:           g.stats.gets.Load(),
+		CacheHits:      g.stats.cacheHits.Load(),
+		Loads:          g.stats.loads.Load(),
+		LoadsDeduped:   g.stats.loadsDeduped.Load(),
+		PeerLoads:      g.stats.peerLoads.Load(),
+		PeerErrors:     g.stats.peerErrors.Load(),
+		PeerRetries:    g.stats.peerRetries.Load(),
+		PeerHedges:     g.stats.peerHedges.Load(),
+		LocalLoads:     g.stats.localLoads.Load(),
+		LocalLoadErrs:  g.stats.localLoadErrs.Load(),
+		ServerRequests: g.stats.serverRequests.Load(),
+		Evictions:      g.mainCache.evictions() + g.hotCache.evictions(),
+		StaleHits:      g.stats.staleHits.Load(),
+		Revalidations:  g.stats.revalidations.Load(),
+		RefreshAheads:  g.stats.refreshAheads.Load(),
+		RefreshErrors:  g.stats.refreshErrors.Load(),
+		NegativeHits:   g.stats.negativeHits.Load(),
+		FilterRejects:  g.stats.filterRejects.Load(),
+		FilterErrors:   g.stats.filterErrors.Load(),
+	}
+}
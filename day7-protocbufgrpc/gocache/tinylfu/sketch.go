@@ -0,0 +1,89 @@
+package tinylfu
+
+import "hash/fnv"
+
+const (
+	sketchDepth = 4
+	// maxCount is where the 4 bit counters saturate
+	maxCount = 15
+	// minWidth is the counters per row for small or unlimited caches
+	minWidth = 1024
+	// avgEntryBytes guesses how many entries fit in maxBytes to size the sketch
+	avgEntryBytes = 64
+)
+
+// cmSketch is a count-min sketch estimating the access frequency of keys, the
+// counters are halved every resetAfter increments so old popularity fades
+type cmSketch struct {
+	rows       [sketchDepth][]uint8
+	seeds      [sketchDepth]uint64
+	mask       uint64
+	additions  int
+	resetAfter int
+}
+
+func newCMSketch(maxBytes int64) *cmSketch {
+	width := minWidth
+	for int64(width) < maxBytes/avgEntryBytes {
+		width <<= 1
+	}
+	s := &cmSketch{
+		mask:       uint64(width - 1),
+		resetAfter: 10 * width,
+		seeds:      [sketchDepth]uint64{0x9e3779b97f4a7c15, 0xbf58476d1ce4e5b9, 0x94d049bb133111eb, 0x2545f4914f6cdd1d},
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// index spreads the key hash with the seed of row i
+func (s *cmSketch) index(h uint64, i int) uint64 {
+	h ^= s.seeds[i]
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	return h & s.mask
+}
+
+func (s *cmSketch) increment(key string) {
+	h := hash(key)
+	for i := range s.rows {
+		if j := s.index(h, i); s.rows[i][j] < maxCount {
+			s.rows[i][j]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.resetAfter {
+		s.reset()
+	}
+}
+
+// estimate is the lowest counter of key, it may overestimate but never underestimates
+func (s *cmSketch) estimate(key string) uint8 {
+	h := hash(key)
+	min := uint8(maxCount)
+	for i := range s.rows {
+		if c := s.rows[i][s.index(h, i)]; c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+// reset halves all the counters
+func (s *cmSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
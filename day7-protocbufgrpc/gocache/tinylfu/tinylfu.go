@@ -0,0 +1,239 @@
+package tinylfu
+
+// W-TinyLFU (Einziger, Friedman & Manes, used by Caffeine and Ristretto) puts new
+// entries into a small LRU window, 1% of the bytes. An entry leaving the window
+// is only admitted into the main SLRU if a count-min sketch has seen it more
+// often than the entry the main cache would evict for it, so a scan of cold keys
+// cannot flush the popular ones. The main SLRU is split into probation (20%) and
+// protected (80%), an entry hit in probation is promoted to protected.
+
+import (
+	"container/list"
+	"gocache/lru"
+	"time"
+)
+
+const (
+	windowPercent    = 1
+	protectedPercent = 80
+)
+
+// Cache is a W-TinyLFU cache. It is not safe for concurrent access.
+type Cache struct {
+	maxBytes  int64
+	evictions int64
+	// bytes limits of window and protected, probation takes the rest of main
+	windowMax    int64
+	protectedMax int64
+	// window, probation and protected are LRU lists, front is most recently used
+	window, probation, protected *segment
+	sketch                       *cmSketch
+	cache                        map[string]*list.Element
+	// optional and executed when an entry is purged.
+	OnEvicted func(key string, value lru.Value)
+}
+
+// entry is data type of DLL node
+type entry struct {
+	key     string
+	value   lru.Value
+	expire  time.Time // zero never expires
+	segment *segment
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.key)) + int64(e.value.Len())
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+// segment is a LRU list with its byte size
+type segment struct {
+	dLL   *list.List
+	bytes int64
+}
+
+func (s *segment) pushFront(kvPair *entry) *list.Element {
+	kvPair.segment = s
+	s.bytes += kvPair.size()
+	return s.dLL.PushFront(kvPair)
+}
+
+func (s *segment) remove(e *list.Element) *entry {
+	kvPair := e.Value.(*entry)
+	s.dLL.Remove(e)
+	s.bytes -= kvPair.size()
+	return kvPair
+}
+
+// New is the Constructor of Cache, maxBytes 0 or less means no limit
+func New(maxBytes int64, onEvicted func(string, lru.Value)) *Cache {
+	windowMax := maxBytes * windowPercent / 100
+	if maxBytes > 0 && windowMax == 0 {
+		windowMax = 1
+	}
+	return &Cache{
+		maxBytes:     maxBytes,
+		windowMax:    windowMax,
+		protectedMax: (maxBytes - windowMax) * protectedPercent / 100,
+		window:       &segment{dLL: list.New()},
+		probation:    &segment{dLL: list.New()},
+		protected:    &segment{dLL: list.New()},
+		sketch:       newCMSketch(maxBytes),
+		cache:        make(map[string]*list.Element),
+		OnEvicted:    onEvicted,
+	}
+}
+
+// Get look ups a key's value and counts the access in the sketch
+// an expired entry is removed lazily here and reported as a miss
+func (c *Cache) Get(key string) (value lru.Value, ok bool) {
+	c.sketch.increment(key)
+	e, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	kvPair := e.Value.(*entry)
+	if kvPair.expired(time.Now()) {
+		c.removeElement(e)
+		return nil, false
+	}
+	c.touch(e)
+	return kvPair.value, true
+}
+
+// touch moves a hit entry to the front of its segment, a probation hit is
+// promoted to protected and the LRU of an overfull protected goes back to probation
+func (c *Cache) touch(e *list.Element) {
+	kvPair := e.Value.(*entry)
+	switch kvPair.segment {
+	case c.window, c.protected:
+		kvPair.segment.dLL.MoveToFront(e)
+	case c.probation:
+		c.probation.remove(e)
+		c.cache[kvPair.key] = c.protected.pushFront(kvPair)
+		for c.protected.bytes > c.protectedMax && c.protected.dLL.Len() > 1 {
+			demoted := c.protected.remove(c.protected.dLL.Back())
+			c.cache[demoted.key] = c.probation.pushFront(demoted)
+		}
+	}
+}
+
+// Add adds a value to the cache.
+func (c *Cache) Add(key string, value lru.Value) {
+	c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithExpire adds a value to the cache which is valid until expire,
+// zero expire means the value never expires.
+func (c *Cache) AddWithExpire(key string, value lru.Value, expire time.Time) {
+	c.sketch.increment(key)
+	if e, ok := c.cache[key]; ok {
+		kvPair := e.Value.(*entry)
+		s := kvPair.segment
+		s.bytes -= kvPair.size()
+		kvPair.value = value
+		kvPair.expire = expire
+		s.bytes += kvPair.size()
+		c.touch(e)
+	} else {
+		c.cache[key] = c.window.pushFront(&entry{key: key, value: value, expire: expire})
+	}
+	// align with lru, if c.maxBytes <= 0 means no limit
+	if c.maxBytes <= 0 {
+		return
+	}
+	// the entries leaving the window ask for admission into main
+	for c.window.bytes > c.windowMax && c.window.dLL.Len() > 0 {
+		candidate := c.window.remove(c.window.dLL.Back())
+		c.cache[candidate.key] = c.probation.pushFront(candidate)
+		c.admit(candidate)
+	}
+	for c.Bytes() > c.maxBytes && len(c.cache) > 0 {
+		c.RemoveOldest()
+	}
+}
+
+// admit makes room in main for the candidate just put at the front of probation,
+// the victims from the probation LRU are evicted while the candidate is more
+// frequent, otherwise the candidate itself is evicted
+func (c *Cache) admit(candidate *entry) {
+	mainMax := c.maxBytes - c.windowMax
+	for c.probation.bytes+c.protected.bytes > mainMax {
+		victim := c.probation.dLL.Back()
+		if victim.Value.(*entry) == candidate {
+			// probation only holds the candidate, take the victim from protected
+			if c.protected.dLL.Len() == 0 {
+				return
+			}
+			victim = c.protected.dLL.Back()
+		}
+		if c.sketch.estimate(candidate.key) <= c.sketch.estimate(victim.Value.(*entry).key) {
+			c.evict(c.cache[candidate.key])
+			return
+		}
+		c.evict(victim)
+	}
+}
+
+// Remove removes the key from the cache, OnEvicted is called if it was there
+func (c *Cache) Remove(key string) {
+	if e, ok := c.cache[key]; ok {
+		c.removeElement(e)
+	}
+}
+
+// RemoveOldest evicts the LRU entry of probation, then protected, then window,
+// named after lru.Cache
+func (c *Cache) RemoveOldest() {
+	for _, s := range []*segment{c.probation, c.protected, c.window} {
+		if e := s.dLL.Back(); e != nil {
+			c.evict(e)
+			return
+		}
+	}
+}
+
+// RemoveExpired removes all the expired entries and returns how many were removed
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	removed := 0
+	for _, e := range c.cache {
+		if e.Value.(*entry).expired(now) {
+			c.removeElement(e)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (c *Cache) evict(e *list.Element) {
+	c.removeElement(e)
+	c.evictions++
+}
+
+func (c *Cache) removeElement(e *list.Element) {
+	kvPair := e.Value.(*entry)
+	kvPair.segment.remove(e)
+	delete(c.cache, kvPair.key)
+	if c.OnEvicted != nil {
+		c.OnEvicted(kvPair.key, kvPair.value)
+	}
+}
+
+// Len the number of cache entries
+func (c *Cache) Len() int {
+	return len(c.cache)
+}
+
+// Bytes the number of bytes used by keys and values
+func (c *Cache) Bytes() int64 {
+	return c.window.bytes + c.probation.bytes + c.protected.bytes
+}
+
+// Evictions the number of entries evicted or not admitted
+func (c *Cache) Evictions() int64 {
+	return c.evictions
+}
@@ -0,0 +1,103 @@
+package tinylfu
+
+import (
+	"fmt"
+	"gocache/lru"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (d String) Len() int {
+	return len(d)
+}
+
+func TestGet(t *testing.T) {
+	c := New(int64(0), nil)
+	c.Add("key1", String("1234"))
+	if v, ok := c.Get("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("cache hit key1=1234 failed")
+	}
+	if _, ok := c.Get("key2"); ok {
+		t.Fatalf("cache miss key2 failed")
+	}
+}
+
+func TestNegativeSize(t *testing.T) {
+	c := New(int64(-1), nil)
+	c.Add("key1", String("1234"))
+	c.Add("key2", String("5678"))
+	if c.Len() != 2 {
+		t.Fatalf("negative maxBytes should not evict, %d left", c.Len())
+	}
+}
+
+func TestOnEvicted(t *testing.T) {
+	evicted := 0
+	c := New(int64(40), func(key string, value lru.Value) {
+		evicted++
+	})
+	for i := 0; i < 20; i++ {
+		c.Add(fmt.Sprintf("k%d", i), String("v1"))
+	}
+	if c.Bytes() > 40 || int64(evicted) != c.Evictions() || c.Len()+evicted != 20 {
+		t.Fatalf("OnEvicted called %d times for %d evictions, %d entries of %d bytes left", evicted, c.Evictions(), c.Len(), c.Bytes())
+	}
+}
+
+func TestScanResistance(t *testing.T) {
+	// room for 25 entries of 4 bytes
+	c := New(int64(100), nil)
+	hot := make([]string, 10)
+	for i := range hot {
+		hot[i] = fmt.Sprintf("h%d", i)
+		c.Add(hot[i], String("v1"))
+		for j := 0; j < 3; j++ {
+			c.Get(hot[i])
+		}
+	}
+	// each cold key is seen once
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("%03d", i)
+		if _, ok := c.Get(key); !ok {
+			c.Add(key, String("v2"))
+		}
+	}
+	for _, key := range hot {
+		if _, ok := c.Get(key); !ok {
+			t.Fatalf("frequent key %s should survive the scan", key)
+		}
+	}
+	if c.Bytes() > 100 {
+		t.Fatalf("expect at most 100 bytes, got %d", c.Bytes())
+	}
+}
+
+func TestSketch(t *testing.T) {
+	s := newCMSketch(0)
+	for i := 0; i < 20; i++ {
+		s.increment("hot")
+	}
+	s.increment("cold")
+	if s.estimate("hot") != maxCount || s.estimate("cold") != 1 || s.estimate("none") != 0 {
+		t.Fatalf("estimates %d %d %d", s.estimate("hot"), s.estimate("cold"), s.estimate("none"))
+	}
+	s.reset()
+	if s.estimate("hot") != maxCount/2 {
+		t.Fatalf("reset should halve the counters, got %d", s.estimate("hot"))
+	}
+}
+
+func TestExpire(t *testing.T) {
+	c := New(int64(0), nil)
+	c.AddWithExpire("k1", String("v1"), time.Now().Add(-time.Second))
+	c.AddWithExpire("k2", String("v2"), time.Now().Add(-time.Second))
+	c.AddWithExpire("k3", String("v3"), time.Now().Add(time.Hour))
+	if _, ok := c.Get("k1"); ok {
+		t.Fatalf("k1 should expire")
+	}
+	if n := c.RemoveExpired(); n != 1 || c.Len() != 1 {
+		t.Fatalf("RemoveExpired should remove k2, removed %d", n)
+	}
+}
@@ -0,0 +1,115 @@
+package gocache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// A Codec encodes the values of a TypedGroup into the cached bytes and returns
+// the Sink decoding them back
+type Codec[T any] interface {
+	Encode(v T) ([]byte, error)
+	Sink(dst *T) Sink
+}
+
+// StringCodec caches strings as they are
+type StringCodec struct{}
+
+func (StringCodec) Encode(v string) ([]byte, error) { return []byte(v), nil }
+
+func (StringCodec) Sink(dst *string) Sink { return StringSink(dst) }
+
+// BytesCodec caches byte slices as they are, the decoded ones are copies
+type BytesCodec struct{}
+
+func (BytesCodec) Encode(v []byte) ([]byte, error) { return v, nil }
+
+func (BytesCodec) Sink(dst *[]byte) Sink { return BytesSink(dst) }
+
+// JSONCodec caches T, e.g. a struct, as json
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(v T) ([]byte, error) { return json.Marshal(v) }
+
+func (JSONCodec[T]) Sink(dst *T) Sink { return JSONSink(dst) }
+
+// ProtoCodec caches the protobuf message T, e.g. *pb.Request, in the wire format
+type ProtoCodec[T proto.Message] struct{}
+
+func (ProtoCodec[T]) Encode(v T) ([]byte, error) { return proto.Marshal(v) }
+
+// Sink allocates a new message into *dst, the generated messages allow
+// ProtoReflect on a nil pointer to create one
+func (ProtoCodec[T]) Sink(dst *T) Sink {
+	*dst = (*dst).ProtoReflect().New().Interface().(T)
+	return ProtoSink(*dst)
+}
+
+// TypedGroup is a Group of values of type T, the getter loads T, the cache keeps
+// the bytes encoded by the codec and Get decodes them again
+type TypedGroup[T any] struct {
+	group *Group
+	codec Codec[T]
+}
+
+// NewTypedGroup creates a Group named name loading the values with getter, see NewGroup,
+// e.g. NewTypedGroup[Student]("students", 64<<20, JSONCodec[Student]{}, loadStudent)
+func NewTypedGroup[T any](name string, maxBytes int64, codec Codec[T], getter func(ctx context.Context, key string) (T, error), opts ...GroupOption) *TypedGroup[T] {
+	if getter == nil {
+		panic("nil Getter")
+	}
+	encode := GetterContextFunc(func(ctx context.Context, key string) ([]byte, error) {
+		v, err := getter(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		return codec.Encode(v)
+	})
+	return &TypedGroup[T]{group: NewGroup(name, maxBytes, encode, opts...), codec: codec}
+}
+
+// Group returns the underlying Group, e.g. for RegisterNodes or Stats
+func (g *TypedGroup[T]) Group() *Group {
+	return g.group
+}
+
+// Get returns the decoded value of key
+func (g *TypedGroup[T]) Get(ctx context.Context, key string) (T, error) {
+	var v T
+	err := g.group.GetSink(ctx, key, g.codec.Sink(&v))
+	return v, err
+}
+
+// GetMany returns the decoded values of keys, see Group.GetMany
+func (g *TypedGroup[T]) GetMany(ctx context.Context, keys []string) (map[string]T, error) {
+	views, err := g.group.GetMany(ctx, keys)
+	values := make(map[string]T, len(views))
+	for key, view := range views {
+		var v T
+		if decodeErr := g.codec.Sink(&v).SetBytes(view.b); decodeErr != nil {
+			if err == nil {
+				err = fmt.Errorf("decoding %s: %v", key, decodeErr)
+			}
+			continue
+		}
+		values[key] = v
+	}
+	return values, err
+}
+
+// Set encodes v and stores it under key, see Group.Set
+func (g *TypedGroup[T]) Set(ctx context.Context, key string, v T) error {
+	b, err := g.codec.Encode(v)
+	if err != nil {
+		return err
+	}
+	return g.group.Set(ctx, key, b)
+}
+
+// Remove invalidates key, see Group.Remove
+func (g *TypedGroup[T]) Remove(ctx context.Context, key string) error {
+	return g.group.Remove(ctx, key)
+}
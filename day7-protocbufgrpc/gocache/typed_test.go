@@ -0,0 +1,54 @@
+package gocache
+
+import (
+	"context"
+	"fmt"
+	pb "gocache/gocachepb"
+	"strconv"
+	"testing"
+)
+
+type student struct {
+	Name  string `json:"name"`
+	Score int    `json:"score"`
+}
+
+func TestTypedGroup(t *testing.T) {
+	loads := 0
+	students := NewTypedGroup[student]("typedstudents", 2<<10, JSONCodec[student]{},
+		func(ctx context.Context, name string) (student, error) {
+			loads++
+			score, ok := db[name]
+			if !ok {
+				return student{}, fmt.Errorf("%s not exist", name)
+			}
+			n, _ := strconv.Atoi(score)
+			return student{Name: name, Score: n}, nil
+		})
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if s, err := students.Get(ctx, "Tom"); err != nil || s != (student{"Tom", 630}) {
+			t.Fatalf("failed to get Tom, got %+v %v", s, err)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("the encoded value should be cached, loaded %d times", loads)
+	}
+	if _, err := students.Get(ctx, "unknown"); err == nil {
+		t.Fatalf("expect the getter error")
+	}
+
+	students.Set(ctx, "Ann", student{"Ann", 700})
+	values, err := students.GetMany(ctx, []string{"Ann", "Jack"})
+	if err != nil || values["Ann"] != (student{"Ann", 700}) || values["Jack"] != (student{"Jack", 589}) {
+		t.Fatalf("unexpected values %+v %v", values, err)
+	}
+
+	requests := NewTypedGroup[*pb.Request]("typedrequests", 2<<10, ProtoCodec[*pb.Request]{},
+		func(ctx context.Context, key string) (*pb.Request, error) {
+			return &pb.Request{Group: "typedrequests", Key: key}, nil
+		})
+	if req, err := requests.Get(ctx, "Tom"); err != nil || req.Key != "Tom" {
+		t.Fatalf("failed to get the Tom message, got %v %v", req, err)
+	}
+}
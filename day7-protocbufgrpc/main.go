@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"gocache"
+	"gocache/consistenthash"
+	"gocache/gossip"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var db = map[string]string{
@@ -16,15 +23,23 @@ var db = map[string]string{
 }
 
 // each group has a httpPool, which contains a hashRing
-func createGroup() *gocache.Group {
+func createGroup(opts ...gocache.GroupOption) *gocache.Group {
 	return gocache.NewGroup("students", 2<<10, gocache.GetterFunc(
 		func(key string) ([]byte, error) {
 			fmt.Printf("[SlowDB] search key %s\n", key)
 			if v, ok := db[key]; ok {
 				return []byte(v), nil
 			}
-			return nil, fmt.Errorf("%s key not exist", key)
-		}))
+			return nil, fmt.Errorf("%s key not exist: %w", key, gocache.ErrNotFound)
+		}), opts...)
+}
+
+// loadKeys lists the keys of the db for the key filter
+func loadKeys(ctx context.Context, add func(key string)) error {
+	for key := range db {
+		add(key)
+	}
+	return nil
 }
 
 // register all nodes into the pool
@@ -36,11 +51,9 @@ func createGroup() *gocache.Group {
 // 	log.Fatal(http.ListenAndServe(addr[7:], pool))
 // }
 
-func startCacheServerGrpc(addr string, addrs []string, group *gocache.Group) {
-	pool := gocache.NewGrpcPool(addr)
-	pool.Add(addrs...)
+func startCacheServerGrpc(pool *gocache.GrpcPool, group *gocache.Group) {
 	group.RegisterNodes(pool)
-	log.Println("gocache is running at", addr)
+	log.Println("gocache is running with peers", pool.Peers())
 	pool.Run()
 }
 
@@ -48,8 +61,22 @@ func startAPIServer(apiAddr string, group *gocache.Group) {
 	http.Handle("/api", http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
 			// we just need to extract key from api addr as group httpPool has its parse /<basepath>/<groupname>/<key> required
+			// ?keys=Tom,Jack gets many keys at once as a json object
+			if keys := r.URL.Query().Get("keys"); keys != "" {
+				views, err := group.GetMany(r.Context(), strings.Split(keys, ","))
+				values := make(map[string]string, len(views))
+				for key, view := range views {
+					values[key] = view.String()
+				}
+				if err != nil {
+					log.Println("[API] GetMany:", err)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(values)
+				return
+			}
 			key := r.URL.Query().Get("key")
-			view, err := group.Get(key)
+			view, err := group.GetContext(r.Context(), key)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -63,28 +90,109 @@ func startAPIServer(apiAddr string, group *gocache.Group) {
 
 }
 
+// expose the Prometheus metrics of the groups and peers and the peer set on the admin port
+func startAdminServer(addr string, pool *gocache.GrpcPool) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", gocache.MetricsHandler())
+	mux.Handle("/admin/peers", gocache.AdminHandler(pool))
+	log.Println("admin server is running at", addr)
+	log.Fatal(http.ListenAndServe(addr, mux))
+}
+
+// parsePeers parses ":8001=4,:8002" into the peers and their weights, 1 by default
+func parsePeers(peers string) map[string]int {
+	weights := make(map[string]int)
+	for _, peer := range strings.Split(peers, ",") {
+		addr, weight, ok := strings.Cut(peer, "=")
+		weights[addr] = 1
+		if ok {
+			w, err := strconv.Atoi(weight)
+			if err != nil {
+				log.Fatalf("bad weight of peer %s: %v", addr, err)
+			}
+			weights[addr] = w
+		}
+	}
+	return weights
+}
+
 func main() {
 	// default arguments
 	var port int
 	var api bool
+	var metricsPort int
+	var peers string
+	var gossipPort int
+	var seeds string
+	var loadEpsilon float64
+	var placement string
+	var replicas int
+	var healthInterval time.Duration
+	var hedgeDelay time.Duration
+	var filter bool
+	var grpcAdmin bool
 	flag.IntVar(&port, "port", 8001, "Gocache server port")
 	flag.BoolVar(&api, "api", false, "Start a api server?")
+	flag.IntVar(&metricsPort, "metrics", 0, "Admin port serving Prometheus /metrics and /admin/peers, 0 disabled")
+	flag.StringVar(&peers, "peers", ":8001,:8002,:8003", "Comma separated addrs of the initial peers, including this one, addr=weight for a weighted peer")
+	flag.IntVar(&gossipPort, "gossip", 0, "Udp port to discover the peers by gossip instead of -peers, 0 disabled")
+	flag.StringVar(&seeds, "seeds", "", "Comma separated gossip addrs of nodes to join, e.g. localhost:7001")
+	flag.Float64Var(&loadEpsilon, "bounded", 0, "Spill keys of peers busier than (1+bounded) times the average, 0 disabled")
+	flag.StringVar(&placement, "placement", "ring", "How keys are placed on the peers: ring, rendezvous or jump")
+	flag.IntVar(&replicas, "replicas", 1, "Number of peers holding each key, the owner and its successors")
+	flag.DurationVar(&healthInterval, "health", 0, "Interval of the health probes of the peers, 0 disabled")
+	flag.DurationVar(&hedgeDelay, "hedge", 0, "Hedge the peer loads slower than this on the next replica or locally, 0 disabled")
+	flag.BoolVar(&filter, "filter", false, "Reject the keys not in the db with a Bloom filter before asking the peers")
+	flag.BoolVar(&grpcAdmin, "grpc-admin", false, "Serve the Admin grpc service on the peer port, it has no auth so keep the port private")
 	flag.Parse()
 
 	apiAddr := "http://localhost:9999"
-	addrMap := map[int]string{
-		8001: ":8001",
-		8002: ":8002",
-		8003: ":8003",
+	pool := gocache.NewGrpcPool(fmt.Sprintf(":%d", port))
+	switch placement {
+	case "rendezvous":
+		pool.SetPlacement(consistenthash.NewRendezvous())
+	case "jump":
+		pool.SetPlacement(consistenthash.NewJump())
 	}
-	var addrs []string
-	for _, v := range addrMap {
-		addrs = append(addrs, v)
+	pool.SetBoundedLoad(loadEpsilon)
+	pool.SetHealthCheck(healthInterval)
+	pool.SetAdmin(grpcAdmin)
+	if gossipPort != 0 {
+		// the members found by gossip replace the peers of the ring as they come and go,
+		// gossip has no weights so the peers it adds all have weight 1
+		_, err := gossip.New(gossip.Config{
+			Name:          fmt.Sprintf(":%d", port),
+			BindAddr:      fmt.Sprintf(":%d", gossipPort),
+			AdvertiseAddr: fmt.Sprintf("localhost:%d", gossipPort),
+			Seeds:         strings.Split(seeds, ","),
+			OnChange:      pool.SetPeers,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		pool.SetPeersWeighted(parsePeers(peers))
 	}
 	// per port/server create a group, api server on port 8003 only
-	group := createGroup()
+	opts := []gocache.GroupOption{gocache.WithReplicas(replicas)}
+	if hedgeDelay > 0 {
+		policy := gocache.DefaultRequestPolicy
+		policy.HedgeDelay = hedgeDelay
+		opts = append(opts, gocache.WithRequestPolicy(policy))
+	}
+	if filter {
+		opts = append(opts, gocache.WithKeyFilter(gocache.FilterPolicy{
+			Keys:    len(db),
+			Loader:  gocache.KeyLoaderFunc(loadKeys),
+			Rebuild: time.Minute,
+		}))
+	}
+	group := createGroup(opts...)
 	if api {
 		go startAPIServer(apiAddr, group)
 	}
-	startCacheServerGrpc(addrMap[port], []string(addrs), group)
+	if metricsPort != 0 {
+		go startAdminServer(fmt.Sprintf(":%d", metricsPort), pool)
+	}
+	startCacheServerGrpc(pool, group)
 }